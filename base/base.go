@@ -9,10 +9,13 @@ import (
 	_ "net/http/pprof"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -43,6 +46,19 @@ func List(c *cli.Context) {
 	list(cs)
 }
 
+// Utils runs the utility selected by the flags of the utils command.
+func Utils(c *cli.Context) {
+	if c.IsSet("relabel") || c.IsSet("R") {
+		fname := c.String("relabel")
+		if fname == "" {
+			fname = c.String("R")
+		}
+		relabelTest(readConf(c), fname)
+		return
+	}
+	ToToml(c)
+}
+
 //转换配置
 func ToToml(c *cli.Context) {
 	var (
@@ -153,10 +169,36 @@ func Start(c *cli.Context) {
 	if len(cs) == 0 {
 		log.Fatalf("Filter %v matches no collectors.", conf.Filter)
 	}
+	all := append([]collectors.Collector(nil), collectors.Search(nil)...)
+	cs = append([]collectors.Collector(nil), cs...)
+	if conf.PutListen != "" {
+		l := &collectors.PutListener{Addr: conf.PutListen}
+		cs, all = append(cs, l), append(all, l)
+	}
+	if conf.StatsdListen != "" {
+		l := &collectors.StatsdListener{Addr: conf.StatsdListen}
+		cs, all = append(cs, l), append(all, l)
+	}
+	cs, err = configureCollectors(cs, all, conf.Collectors)
+	if err != nil {
+		log.Fatal(err)
+	}
 	for _, col := range cs {
 		col.Init()
 	}
-	u, err := parseHost(conf.Host)
+	var u *url.URL
+	if strings.Contains(conf.Host, ",") {
+		s, err := newBalancedSink(conf.Host, conf.HostStrategy, conf.DeadLetter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := collect.AddSink(s, 0, 0); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("OpenTSDB hosts: %s (%s)", conf.Host, conf.HostStrategy)
+	} else {
+		u, _ = parseHost(conf.Host)
+	}
 	su, _ := parseHost(conf.SchedHost) // add by xuye 20160525
 
 	freq := time.Second * time.Duration(conf.Freq)
@@ -165,25 +207,147 @@ func Start(c *cli.Context) {
 	}
 	collectors.DefaultFreq = freq
 	collect.Freq = freq
+	collectors.Milliseconds = conf.Milliseconds
+	collect.Milliseconds = conf.Milliseconds
 	if conf.BatchSize < 0 {
 		log.Fatal("BatchSize must be > 0")
 	}
 	if conf.BatchSize != 0 {
 		collect.BatchSize = conf.BatchSize
 	}
+	collect.SpoolDir = conf.Spool.Dir
+	collect.DeadLetter = conf.DeadLetter
+	if conf.Spool.MaxBytes < 0 {
+		log.Fatal("Spool.MaxBytes must be >= 0")
+	}
+	if conf.Spool.MaxBytes != 0 {
+		collect.SpoolMaxBytes = conf.Spool.MaxBytes
+	}
+	parseDuration := func(name, s string, d *time.Duration) {
+		if s == "" {
+			return
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", name, err)
+		}
+		*d = v
+	}
+	parseDuration("Spool.MaxAge", conf.Spool.MaxAge, &collect.SpoolMaxAge)
+	parseDuration("Retry.MinBackoff", conf.Retry.MinBackoff, &collect.RetryMinBackoff)
+	parseDuration("Retry.MaxBackoff", conf.Retry.MaxBackoff, &collect.RetryMaxBackoff)
+	parseDuration("Retry.MaxAge", conf.Retry.MaxAge, &collect.MaxRetryAge)
+	parseDuration("FlushInterval", conf.FlushInterval, &collect.FlushInterval)
+	if conf.BatchBytes < 0 || conf.Senders < 0 || collect.FlushInterval <= 0 {
+		log.Fatal("BatchBytes and Senders must be >= 0 and FlushInterval > 0")
+	}
+	collect.BatchBytes = conf.BatchBytes
+	if conf.Senders != 0 {
+		collect.Senders = conf.Senders
+	}
+	parseDuration("Cardinality.Window", conf.Cardinality.Window, &collect.SeriesWindow)
+	shutdownTimeout := time.Second * 30
+	parseDuration("ShutdownTimeout", conf.ShutdownTimeout, &shutdownTimeout)
+	if collect.RetryMinBackoff <= 0 || collect.RetryMaxBackoff < collect.RetryMinBackoff {
+		log.Fatal("Retry.MinBackoff must be > 0 and <= Retry.MaxBackoff")
+	}
+	if conf.Retry.MaxRetries < 0 {
+		log.Fatal("Retry.MaxRetries must be >= 0")
+	}
+	collect.MaxRetries = conf.Retry.MaxRetries
+	if conf.Retry.BreakerThreshold != 0 {
+		collect.BreakerThreshold = conf.Retry.BreakerThreshold
+	}
+	rules, err := newRelabelRules(conf.Relabel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	collect.RelabelRules = rules
+	if conf.Cardinality.MaxSeries < 0 || collect.SeriesWindow <= 0 {
+		log.Fatal("Cardinality.MaxSeries must be >= 0 and Cardinality.Window > 0")
+	}
+	collect.MaxSeries = conf.Cardinality.MaxSeries
+	var limits []string
+	for m := range conf.Cardinality.Limits {
+		limits = append(limits, m)
+	}
+	// The longest, most specific, pattern wins.
+	sort.Slice(limits, func(i, j int) bool {
+		if len(limits[i]) != len(limits[j]) {
+			return len(limits[i]) > len(limits[j])
+		}
+		return limits[i] < limits[j]
+	})
+	for _, m := range limits {
+		p, err := collect.NewPattern(m)
+		if err != nil {
+			log.Fatalf("Cardinality.Limits: %v", err)
+		}
+		collect.SeriesLimits = append(collect.SeriesLimits, collect.SeriesLimit{Metric: p, Max: conf.Cardinality.Limits[m]})
+	}
+	collect.Dedup = conf.Dedup.Enabled
+	if conf.Dedup.Heartbeat < 0 {
+		log.Fatal("Dedup.Heartbeat must be >= 0")
+	}
+	if conf.Dedup.Heartbeat > 0 {
+		collect.DedupHeartbeat = freq * time.Duration(conf.Dedup.Heartbeat)
+	} else {
+		collect.DedupHeartbeat = freq * 10
+	}
+	for _, o := range conf.Output {
+		if !collect.Dedup || o.Type != "prometheus" || collect.DedupHeartbeat < collect.RemoteWriteStaleness {
+			continue
+		}
+		// Prometheus would mark unchanged series stale between heartbeats.
+		if conf.Dedup.Heartbeat > 0 {
+			log.Fatalf("Dedup.Heartbeat must be shorter than %s with a prometheus output", collect.RemoteWriteStaleness)
+		}
+		collect.DedupHeartbeat = freq * ((collect.RemoteWriteStaleness - 1) / freq)
+	}
+	if collect.DedupInclude, err = newPatterns(conf.Dedup.Include); err != nil {
+		log.Fatalf("Dedup.Include: %v", err)
+	}
+	if collect.DedupExclude, err = newPatterns(conf.Dedup.Exclude); err != nil {
+		log.Fatalf("Dedup.Exclude: %v", err)
+	}
+	for _, r := range conf.Rate {
+		rule, err := newRateRule(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		collect.RateRules = append(collect.RateRules, rule)
+	}
 	collect.Tags = opentsdb.TagSet{"os": runtime.GOOS}
 	if c.IsSet("print") {
 		collect.Print = c.Bool("print")
 	} else if c.IsSet("P") {
 		collect.Print = c.Bool("P")
 	}
+	if err := setupHTTP(conf.HTTP); err != nil {
+		log.Fatal(err)
+	}
 	if !c.IsSet("dismetadata") && !c.IsSet("M") {
-		log.Debug(u.String())
+		log.Debug(su)
 		if err := metadata.Init(su); err != nil {
 			log.Fatal(err)
 		}
 	}
 
+	if conf.MetricsListen != "" {
+		if err := collect.ServeMetrics(conf.MetricsListen); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, o := range conf.Output {
+		s, err := newSink(o)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := collect.AddSink(s, o.BatchSize, o.MaxQueueLen); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("output %s: %s", s.Name(), o.Type)
+	}
 	cdp := collectors.Run(cs)
 	if u != nil {
 		log.Infoln("OpenTSDB host:", u)
@@ -212,17 +376,51 @@ func Start(c *cli.Context) {
 	} else if c.IsSet("B") {
 		collect.BatchSize = c.Int("B")
 	}
+	go newMemoryGuard(conf.Memory, shutdownTimeout).run()
+	shutdown(shutdownTimeout)
+}
+
+// shutdown waits for SIGTERM or SIGINT, then stops the collectors and sends
+// the queued data and metadata for up to timeout before exiting. Data that
+// could not be sent is spooled; the exit status is 1 if some was lost. A
+// second signal exits immediately.
+func shutdown(timeout time.Duration) {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	log.Infof("received %v, flushing queued data for up to %s", s, timeout)
 	go func() {
-		const maxMem = 500 * 1024 * 1024 // 500MB
-		var m runtime.MemStats
-		for range time.Tick(time.Minute) {
-			runtime.ReadMemStats(&m)
-			if m.Alloc > maxMem {
-				panic("memory max reached")
-			}
-		}
+		s := <-sig
+		log.Errorf("received %v again, exiting without flushing", s)
+		os.Exit(2)
+	}()
+	exit(timeout, 0)
+}
+
+// exit stops the collectors, sends the queued data and metadata for up to
+// timeout and exits with status, or 1 if data was lost.
+func exit(timeout time.Duration, status int) {
+	collectors.Stop()
+	// Metadata is sent while the queues are flushed so that an unreachable
+	// metadata host can't use up the timeout before any data is spooled.
+	deadline := time.After(timeout)
+	sent := make(chan struct{})
+	go func() {
+		metadata.SendAll()
+		close(sent)
 	}()
-	select {}
+	lost := collect.Flush(timeout)
+	select {
+	case <-sent:
+	case <-deadline:
+		log.Error("exiting: metadata not sent before the shutdown timeout")
+	}
+	if lost > 0 {
+		log.Errorf("exiting: %d data points lost", lost)
+		os.Exit(1)
+	}
+	log.Info("exiting")
+	os.Exit(status)
 }
 
 func list(cs []collectors.Collector) {
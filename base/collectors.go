@@ -0,0 +1,77 @@
+package base
+
+import (
+	"fmt"
+	"time"
+
+	"mosun_collector/collector/collectors"
+	"mosun_collector/collector/conf"
+)
+
+// configureCollectors applies the [Collectors.<name>] sections of c to the
+// collectors. cs are the collectors selected by Filter, out of all. It
+// returns the collectors to run.
+func configureCollectors(cs, all []collectors.Collector, c map[string]conf.Collector) ([]collectors.Collector, error) {
+	known := make(map[string]bool)
+	for _, col := range all {
+		known[col.Name()] = true
+	}
+	for name := range c {
+		if !known[name] {
+			return nil, fmt.Errorf("Collectors.%s: unknown collector, see the list command", name)
+		}
+	}
+	selected := make(map[string]bool)
+	for _, col := range cs {
+		selected[col.Name()] = true
+	}
+	var run []collectors.Collector
+	for _, col := range all {
+		name := col.Name()
+		cc, ok := c[name]
+		if ok && cc.Enabled != nil {
+			if !*cc.Enabled {
+				continue
+			}
+		} else if !selected[name] {
+			continue
+		}
+		if !ok {
+			run = append(run, col)
+			continue
+		}
+		var s collectors.Settings
+		if err := parseCollectorDuration(cc.Interval, &s.Interval); err != nil {
+			return nil, fmt.Errorf("Collectors.%s: Interval: %v", name, err)
+		}
+		if err := parseCollectorDuration(cc.Timeout, &s.Timeout); err != nil {
+			return nil, fmt.Errorf("Collectors.%s: Timeout: %v", name, err)
+		}
+		s.Tags = cc.Tags
+		s.Prefix = cc.Prefix
+		col, err := collectors.Configure(col, s)
+		if err != nil {
+			return nil, fmt.Errorf("Collectors.%s: %v", name, err)
+		}
+		run = append(run, col)
+	}
+	if len(run) == 0 {
+		return nil, fmt.Errorf("no collectors enabled")
+	}
+	return run, nil
+}
+
+func parseCollectorDuration(s string, d *time.Duration) error {
+	if s == "" {
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return fmt.Errorf("must be > 0")
+	}
+	*d = v
+	return nil
+}
@@ -8,7 +8,7 @@ var (
 	FlHost = cli.StringFlag{
 		Name:  "host, H",
 		Value: "",
-		Usage: "OpenTSDB or Bosun host to send data. Overrides Host in conf file.",
+		Usage: "OpenTSDB or Bosun host to send data, or several separated by commas. Overrides Host in conf file.",
 	}
 
 	FlSchedHost = cli.StringFlag{
@@ -72,6 +72,12 @@ var (
 		Usage: "Location of configuration file. Defaults to scollector.toml in directory of the scollector executable.",
 	}
 
+	FlRelabel = cli.StringFlag{
+		Name:  "relabel, R",
+		Value: "",
+		Usage: "Applies the Relabel rules of -conf to the data points read from this file, - for stdin, and prints the result. One data point per line, as an OpenTSDB put line or JSON.",
+	}
+
 	FlToToml = cli.StringFlag{
 		Name:  "totoml, T",
 		Value: "",
@@ -0,0 +1,118 @@
+package base
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/collect"
+	"mosun_collector/collector/collectors"
+	"mosun_collector/collector/conf"
+	"mosun_collector/metadata"
+)
+
+// memoryGuard sheds load when the heap grows above the soft limit, one step
+// per check: first the queue is moved to the spool, then collection slows
+// down, then the collectors listed in Shed stop running one by one. Each
+// step is undone once memory is back well below the soft limit. Only when
+// every step has been taken and the heap is still above the hard limit does
+// the collector exit, after spooling what it could not send.
+type memoryGuard struct {
+	max, soft uint64
+	slowdown  int
+	shed      []string
+	timeout   time.Duration // time given to the shutdown flush
+
+	level int // number of steps taken
+}
+
+func newMemoryGuard(c conf.Memory, timeout time.Duration) *memoryGuard {
+	g := &memoryGuard{
+		max:      c.MaxBytes,
+		soft:     c.SoftBytes,
+		slowdown: c.Slowdown,
+		shed:     c.Shed,
+		timeout:  timeout,
+	}
+	if g.max == 0 {
+		g.max = 500 * 1024 * 1024 // 500MB
+	}
+	if g.soft == 0 || g.soft > g.max {
+		g.soft = g.max / 10 * 8
+	}
+	if g.slowdown < 2 {
+		g.slowdown = 4
+	}
+	return g
+}
+
+// maxLevel is the level at which every step has been taken.
+func (g *memoryGuard) maxLevel() int {
+	return 2 + len(g.shed)
+}
+
+func (g *memoryGuard) run() {
+	if !collect.DisableDefaultCollectors {
+		metadata.AddMetricMeta("collector.collect.memory.level", metadata.Gauge, metadata.None,
+			"Number of load shedding steps taken because of high memory usage. 0=none, 1=queue spooled, 2=slower collection, 3 and more=collectors stopped.")
+	}
+	var m runtime.MemStats
+	for range time.Tick(time.Second * 10) {
+		runtime.ReadMemStats(&m)
+		g.check(m.Alloc)
+		g.report()
+	}
+}
+
+// report sends the current level as a self metric, unless self metrics are
+// disabled.
+func (g *memoryGuard) report() {
+	if !collect.DisableDefaultCollectors {
+		collect.Put("collect.memory.level", collect.Tags, g.level)
+	}
+}
+
+func (g *memoryGuard) check(alloc uint64) {
+	switch {
+	case alloc > g.soft && g.level < g.maxLevel():
+		g.level++
+		log.Warnf("memory: %d bytes allocated, above %d: shedding load, level %d", alloc, g.soft, g.level)
+		g.apply()
+	case alloc > g.soft:
+		g.apply()
+	case alloc < g.soft/4*3 && g.level > 0:
+		g.level--
+		log.Infof("memory: %d bytes allocated: restoring load, level %d", alloc, g.level)
+		g.apply()
+	}
+	if alloc <= g.max || g.level < g.maxLevel() {
+		return
+	}
+	debug.FreeOSMemory()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.Alloc > g.max {
+		log.Errorf("memory: %d bytes allocated, above %d after shedding all load: exiting", m.Alloc, g.max)
+		g.report()
+		exit(g.timeout, 3)
+	}
+}
+
+// apply makes collection match the current level.
+func (g *memoryGuard) apply() {
+	if g.level >= 1 {
+		if n := collect.Spill(); n > 0 {
+			log.Warnf("memory: spooled %d queued data points", n)
+		}
+	}
+	factor := 1
+	if g.level >= 2 {
+		factor = g.slowdown
+	}
+	var shed []string
+	if g.level > 2 {
+		shed = g.shed[:g.level-2]
+	}
+	collectors.Throttle(factor, shed)
+}
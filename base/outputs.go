@@ -0,0 +1,127 @@
+package base
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"mosun_collector/collect"
+	"mosun_collector/collector/conf"
+	"mosun_collector/metadata"
+	"mosun_collector/opentsdb"
+	"mosun_collector/util"
+)
+
+// setupHTTP configures the transport shared by the data and metadata sends.
+func setupHTTP(h conf.HTTP) error {
+	t, err := util.NewTransport(h.CAFile, h.CertFile, h.KeyFile, h.InsecureSkipVerify, h.Proxy)
+	if err != nil {
+		return err
+	}
+	header := make(http.Header)
+	for k, v := range h.Headers {
+		header.Set(k, v)
+	}
+	if h.Username != "" || h.Password != "" {
+		if h.BearerToken != "" {
+			return fmt.Errorf("HTTP: Username and BearerToken are mutually exclusive")
+		}
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(h.Username+":"+h.Password)))
+	} else if h.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+	collect.SetTransport(t, header)
+	metadata.SetTransport(t, header)
+	return nil
+}
+
+// newSink creates the collect.Sink described by o.
+func newSink(o conf.Output) (collect.Sink, error) {
+	name := o.Name
+	if name == "" {
+		name = o.Type
+	}
+	switch o.Type {
+	case "opentsdb":
+		u, err := parseHost(o.Host)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", name, err)
+		}
+		return collect.NewOpenTSDBSink(name, u, o.DeadLetter)
+	case "prometheus":
+		u, err := parseHost(o.Host)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", name, err)
+		}
+		return collect.NewRemoteWriteSink(name, u), nil
+	case "influxdb":
+		u, err := parseHost(o.Host)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", name, err)
+		}
+		return collect.NewInfluxDBSink(name, u, o.Field, o.SplitField), nil
+	case "graphite":
+		if o.Host == "" {
+			return nil, fmt.Errorf("output %s: no host specified", name)
+		}
+		return collect.NewGraphiteSink(name, o.Host, o.Prefix, o.Tagged), nil
+	case "telnet":
+		if o.Host == "" {
+			return nil, fmt.Errorf("output %s: no host specified", name)
+		}
+		return collect.NewTelnetSink(name, o.Host), nil
+	case "file":
+		if o.Path == "" {
+			return nil, fmt.Errorf("output %s: no path specified", name)
+		}
+		return collect.NewFileSink(name, o.Path)
+	default:
+		return nil, fmt.Errorf("output %s: unknown type %q", name, o.Type)
+	}
+}
+
+// newRateRule returns the counter to rate conversion configured by r.
+func newRateRule(r conf.Rate) (collect.RateRule, error) {
+	if r.Metric == "" {
+		return collect.RateRule{}, fmt.Errorf("Rate: no Metric specified")
+	}
+	p, err := collect.NewPattern(r.Metric)
+	if err != nil {
+		return collect.RateRule{}, fmt.Errorf("Rate: %v", err)
+	}
+	rule := collect.RateRule{Metric: p, Suffix: r.Suffix, Keep: r.Keep}
+	switch r.Bits {
+	case 0:
+	case 32:
+		rule.Max = math.MaxUint32
+	case 64:
+		rule.Max = math.MaxUint64
+	default:
+		return rule, fmt.Errorf("Rate %s: Bits must be 32 or 64", r.Metric)
+	}
+	if r.Suffix == "" {
+		// The collectors keep documenting the counter under its own name.
+		return rule, fmt.Errorf("Rate %s: no Suffix specified", r.Metric)
+	}
+	return rule, nil
+}
+
+// newBalancedSink returns the default output for the comma separated list
+// of OpenTSDB hosts.
+func newBalancedSink(hosts, strategy, deadLetter string) (collect.Sink, error) {
+	var sinks []collect.Sink
+	for _, h := range strings.Split(hosts, ",") {
+		u, err := parseHost(strings.TrimSpace(h))
+		if err != nil {
+			return nil, fmt.Errorf("Host %s: %v", h, err)
+		}
+		s, err := collect.NewOpenTSDBSink(opentsdb.MustReplace(u.Host, "_"), u, deadLetter)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return collect.NewBalancedSink("default", strategy, sinks)
+}
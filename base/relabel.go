@@ -0,0 +1,192 @@
+package base
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/collect"
+	"mosun_collector/collector/conf"
+	"mosun_collector/opentsdb"
+)
+
+// newRelabelRules returns the relabel rules configured by rs.
+func newRelabelRules(rs []conf.Relabel) ([]collect.RelabelRule, error) {
+	var rules []collect.RelabelRule
+	for i, r := range rs {
+		rule, err := newRelabelRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("Relabel %d: %v", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func newRelabelRule(r conf.Relabel) (collect.RelabelRule, error) {
+	rule := collect.RelabelRule{
+		Action: r.Action,
+		Tag:    r.Tag,
+		Value:  r.Value,
+	}
+	if r.Metric != "" {
+		p, err := collect.NewPattern(r.Metric)
+		if err != nil {
+			return rule, err
+		}
+		rule.Metric = p
+	}
+	for k, v := range r.Tags {
+		p, err := collect.NewPattern(v)
+		if err != nil {
+			return rule, err
+		}
+		if rule.Tags == nil {
+			rule.Tags = make(map[string]*collect.Pattern)
+		}
+		rule.Tags[k] = p
+	}
+	switch r.Action {
+	case collect.RelabelDrop, collect.RelabelKeep:
+	case collect.RelabelRename:
+		if r.Value == "" {
+			return rule, fmt.Errorf("rename requires a Value")
+		}
+		if err := checkClean(r.Value, "Value"); err != nil {
+			return rule, err
+		}
+	case collect.RelabelSetTag:
+		if r.Tag == "" || r.Value == "" {
+			return rule, fmt.Errorf("settag requires a Tag and a Value")
+		}
+		if err := checkClean(r.Tag, "Tag"); err != nil {
+			return rule, err
+		}
+		if err := checkClean(r.Value, "Value"); err != nil {
+			return rule, err
+		}
+	case collect.RelabelDelTag:
+		if r.Tag == "" {
+			return rule, fmt.Errorf("deltag requires a Tag")
+		}
+	case collect.RelabelReplace:
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return rule, err
+		}
+		rule.Regex = re
+	default:
+		return rule, fmt.Errorf("unknown Action %q", r.Action)
+	}
+	return rule, nil
+}
+
+// checkClean returns an error if s, the what of a rule, is not a valid
+// metric name or tag: every data point it is set on would be dropped.
+func checkClean(s, what string) error {
+	if c, err := opentsdb.Clean(s); err != nil || c != s {
+		return fmt.Errorf("invalid %s %q: may only contain a to z, A to Z, 0 to 9, -, _, ., / or Unicode letters", what, s)
+	}
+	return nil
+}
+
+// relabelTest prints what the Relabel rules of c do to the data points read
+// from fname.
+func relabelTest(c *conf.Conf, fname string) {
+	rules, err := newRelabelRules(c.Relabel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var r io.Reader = os.Stdin
+	if fname != "-" {
+		f, err := os.Open(fname)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dp, err := parseDataPoint(line)
+		if err != nil {
+			fmt.Printf("%s\n\terror: %v\n", line, err)
+			continue
+		}
+		fmt.Println(putLine(dp))
+		out := collect.Relabel(rules, []*opentsdb.DataPoint{dp})
+		if len(out) == 0 {
+			fmt.Println("\tdropped")
+			continue
+		}
+		fmt.Printf("\t%s\n", putLine(out[0]))
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseDataPoint parses a JSON data point or an OpenTSDB put line:
+// [put] <metric> <timestamp> <value> <tagk1=tagv1 ...>.
+func parseDataPoint(line string) (*opentsdb.DataPoint, error) {
+	dp := &opentsdb.DataPoint{Tags: make(opentsdb.TagSet)}
+	if strings.HasPrefix(line, "{") {
+		err := json.Unmarshal([]byte(line), dp)
+		return dp, err
+	}
+	f := strings.Fields(line)
+	if len(f) > 0 && f[0] == "put" {
+		f = f[1:]
+	}
+	if len(f) < 3 {
+		return nil, fmt.Errorf("expected metric, timestamp and value")
+	}
+	ts, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	v, err := strconv.ParseFloat(f[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	dp.Metric, dp.Timestamp, dp.Value = f[0], ts, v
+	for _, t := range f[3:] {
+		kv := strings.SplitN(t, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad tag %q", t)
+		}
+		dp.Tags[kv[0]] = kv[1]
+	}
+	return dp, nil
+}
+
+func putLine(dp *opentsdb.DataPoint) string {
+	s := fmt.Sprintf("%s %d %v", dp.Metric, dp.Timestamp, dp.Value)
+	if t := dp.Tags.Tags(); t != "" {
+		s += " " + strings.Replace(t, ",", " ", -1)
+	}
+	return s
+}
+
+// newPatterns parses every string of ss with collect.NewPattern.
+func newPatterns(ss []string) ([]*collect.Pattern, error) {
+	var ps []*collect.Pattern
+	for _, s := range ss {
+		p, err := collect.NewPattern(s)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
@@ -0,0 +1,242 @@
+package collect
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/metadata"
+	"mosun_collector/opentsdb"
+)
+
+// Host selection strategies of NewBalancedSink.
+const (
+	// StrategyFailover sends to the first healthy host, in order.
+	StrategyFailover = "failover"
+	// StrategyRoundRobin sends each batch to the next healthy host.
+	StrategyRoundRobin = "roundrobin"
+	// StrategyHash sends each series to the same host, chosen by rendezvous
+	// hashing, so that only the series of a failed host move.
+	StrategyHash = "hash"
+)
+
+const descCollectHostUp = "Whether the host of the output is considered healthy. 1=up, 0=down."
+
+// hostState is the health of a host of a balanced sink. A host is down
+// after a failed send and is tried again once its backoff has passed.
+type hostState struct {
+	sink     Sink
+	hash     uint64 // hash of the sink name, for StrategyHash
+	failures int
+	retryAt  time.Time
+}
+
+func (h *hostState) up(now time.Time) bool {
+	return h.failures == 0 || !now.Before(h.retryAt)
+}
+
+type balancedSink struct {
+	name     string
+	strategy string
+	next     uint32 // next host for StrategyRoundRobin
+
+	sync.Mutex // protects hosts
+	hosts      []*hostState
+}
+
+// NewBalancedSink returns a sink spreading data over sinks, one per host,
+// according to strategy. A host failing to accept data is marked down and
+// its data goes to the other hosts until its backoff, growing from
+// RetryMinBackoff to RetryMaxBackoff, has passed. Send only fails if no host
+// accepted the data. With StrategyHash, the data already sent to other hosts
+// is then sent again when the batch is retried.
+func NewBalancedSink(name, strategy string, sinks []Sink) (Sink, error) {
+	switch strategy {
+	case "":
+		strategy = StrategyFailover
+	case StrategyFailover, StrategyRoundRobin, StrategyHash:
+	default:
+		return nil, fmt.Errorf("unknown host strategy %q", strategy)
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("%s: no host specified", name)
+	}
+	s := &balancedSink{name: name, strategy: strategy}
+	for _, sink := range sinks {
+		if err := checkClean(sink.Name(), "host name"); err != nil {
+			return nil, err
+		}
+		h := fnv.New64a()
+		h.Write([]byte(sink.Name()))
+		s.hosts = append(s.hosts, &hostState{sink: sink, hash: h.Sum64()})
+	}
+	return s, nil
+}
+
+func (s *balancedSink) Name() string { return s.name }
+
+func (s *balancedSink) Send(batch []*opentsdb.DataPoint) error {
+	if s.strategy == StrategyHash {
+		return s.sendHashed(batch)
+	}
+	hosts := s.upHosts()
+	if len(hosts) == 0 {
+		return fmt.Errorf("%s: all hosts are down", s.name)
+	}
+	start := 0
+	if s.strategy == StrategyRoundRobin {
+		start = int(atomic.AddUint32(&s.next, 1)-1) % len(hosts)
+	}
+	var err error
+	for i := range hosts {
+		err = s.sendTo(hosts[(start+i)%len(hosts)], batch)
+		if _, ok := err.(*rejectedError); err == nil || ok {
+			return err
+		}
+	}
+	return err
+}
+
+// upHosts returns the healthy hosts, in order.
+func (s *balancedSink) upHosts() []*hostState {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	var hosts []*hostState
+	for _, h := range s.hosts {
+		if h.up(now) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// sendHashed sends every data point to the healthy host with the highest
+// rendezvous hash for its series. The data points of a failing host are sent
+// to their next choice.
+func (s *balancedSink) sendHashed(batch []*opentsdb.DataPoint) error {
+	failed := make(map[*hostState]bool)
+	var err error
+	var rejected []string
+	n := 0
+	for len(batch) > 0 {
+		groups := make(map[*hostState][]*opentsdb.DataPoint)
+		var orphans int
+		for _, dp := range batch {
+			h := s.pick(dp, failed)
+			if h == nil {
+				orphans++
+				continue
+			}
+			groups[h] = append(groups[h], dp)
+		}
+		if orphans > 0 {
+			if err == nil {
+				err = fmt.Errorf("%s: all hosts are down", s.name)
+			}
+			return fmt.Errorf("%d data points not sent: %v", orphans, err)
+		}
+		batch = batch[:0:0]
+		for h, dps := range groups {
+			e := s.sendTo(h, dps)
+			if r, ok := e.(*rejectedError); ok {
+				n += r.n
+				rejected = append(rejected, r.msg)
+			} else if e != nil {
+				err = e
+				failed[h] = true
+				batch = append(batch, dps...)
+			}
+		}
+	}
+	if n > 0 {
+		return &rejectedError{n: n, msg: strings.Join(rejected, "; ")}
+	}
+	return nil
+}
+
+// pick returns the healthy host, not in failed, ranking highest for the
+// series of dp.
+func (s *balancedSink) pick(dp *opentsdb.DataPoint, failed map[*hostState]bool) *hostState {
+	f := fnv.New64a()
+	f.Write([]byte(dp.Metric))
+	f.Write([]byte(dp.Tags.String()))
+	series := f.Sum64()
+	var best *hostState
+	var bestScore uint64
+	for _, h := range s.hosts {
+		if failed[h] || !s.isUp(h) {
+			continue
+		}
+		if score := mix(series ^ h.hash); best == nil || score > bestScore {
+			best, bestScore = h, score
+		}
+	}
+	return best
+}
+
+// mix is the splitmix64 finalizer, spreading the bits of x.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (s *balancedSink) isUp(h *hostState) bool {
+	s.Lock()
+	defer s.Unlock()
+	return h.up(time.Now())
+}
+
+// sendTo sends batch to h and updates its health.
+func (s *balancedSink) sendTo(h *hostState, batch []*opentsdb.DataPoint) error {
+	err := h.sink.Send(batch)
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := err.(*rejectedError); err == nil || ok {
+		if h.failures > 0 {
+			log.Infof("%s: host %s is up", s.name, h.sink.Name())
+		}
+		h.failures = 0
+		return err
+	}
+	h.failures++
+	backoff := RetryMinBackoff << uint(h.failures-1)
+	if backoff > RetryMaxBackoff || backoff <= 0 {
+		backoff = RetryMaxBackoff
+	}
+	h.retryAt = time.Now().Add(backoff)
+	log.Errorf("%s: host %s is down for %s: %v", s.name, h.sink.Name(), backoff, err)
+	return err
+}
+
+func (s *balancedSink) bytesSent() (n int64) {
+	for _, h := range s.hosts {
+		if bc, ok := h.sink.(byteCounter); ok {
+			n += bc.bytesSent()
+		}
+	}
+	return n
+}
+
+func (s *balancedSink) initMetrics(tags opentsdb.TagSet) {
+	metadata.AddMetricMeta(metricRoot+"collect.host.up", metadata.Gauge, metadata.Ok, descCollectHostUp)
+	for _, h := range s.hosts {
+		h := h
+		Set("collect.host.up", tags.Copy().Merge(opentsdb.TagSet{"target": h.sink.Name()}), func() interface{} {
+			s.Lock()
+			defer s.Unlock()
+			if h.failures == 0 {
+				return 1
+			}
+			return 0
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package collect
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"mosun_collector/opentsdb"
+)
+
+type testSink struct {
+	name string
+	fail bool
+	got  int
+}
+
+func (s *testSink) Name() string { return s.name }
+
+func (s *testSink) Send(batch []*opentsdb.DataPoint) error {
+	if s.fail {
+		return fmt.Errorf("%s failed", s.name)
+	}
+	s.got += len(batch)
+	return nil
+}
+
+func TestBalancedSink(t *testing.T) {
+	defer func(min time.Duration) { RetryMinBackoff = min }(RetryMinBackoff)
+	RetryMinBackoff = time.Hour
+	var batch []*opentsdb.DataPoint
+	for i := 0; i < 100; i++ {
+		batch = append(batch, testPoint("test.balance", 1, i, "i", fmt.Sprint(i)))
+	}
+	for _, strategy := range []string{StrategyFailover, StrategyRoundRobin, StrategyHash} {
+		a, b, c := &testSink{name: "a", fail: true}, &testSink{name: "b"}, &testSink{name: "c"}
+		s, err := NewBalancedSink("test", strategy, []Sink{a, b, c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := s.Send(batch); err != nil {
+				t.Fatalf("%s: %v", strategy, err)
+			}
+		}
+		if b.got+c.got != 200 {
+			t.Errorf("%s: expected 200 data points sent, got %d", strategy, b.got+c.got)
+		}
+		switch strategy {
+		case StrategyFailover:
+			if b.got != 200 {
+				t.Errorf("%s: expected all data on b, got %d", strategy, b.got)
+			}
+		case StrategyRoundRobin, StrategyHash:
+			if b.got == 0 || c.got == 0 {
+				t.Errorf("%s: expected data on b and c, got %d and %d", strategy, b.got, c.got)
+			}
+		}
+		b.fail, c.fail = true, true
+		if err := s.Send(batch); err == nil {
+			t.Errorf("%s: expected error with all hosts down", strategy)
+		}
+	}
+}
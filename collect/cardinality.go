@@ -0,0 +1,158 @@
+package collect
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/opentsdb"
+)
+
+var (
+	// MaxSeries is the number of distinct tag sets a metric may have within
+	// SeriesWindow. Data points of new series past the limit are dropped.
+	// Zero disables the limit.
+	MaxSeries int
+
+	// SeriesLimits overrides MaxSeries for the metrics they match. The first
+	// matching limit wins.
+	SeriesLimits []SeriesLimit
+
+	// SeriesWindow is how long a series counts towards the limit after its
+	// last data point.
+	SeriesWindow = time.Hour
+)
+
+// SeriesLimit is the maximum number of series of the metrics matching
+// Metric. Zero means no limit.
+type SeriesLimit struct {
+	Metric *Pattern
+	Max    int
+}
+
+const (
+	descCollectCardinalityRejected = "Number of distinct series of the metric refused within the series window because it has too many series."
+	descCollectCardinalityDropped  = "Number of data points of the metric dropped because it has too many series."
+)
+
+// metricSeries holds the last time each accepted and refused series of a
+// metric was seen.
+type metricSeries struct {
+	max      int
+	series   map[string]time.Time
+	refused  map[string]time.Time
+	dropped  int64 // number of refused data points
+	reported bool  // the self metrics are registered or pending
+}
+
+// cardinality is the per-metric series limiter.
+type cardinality struct {
+	sync.Mutex
+	metrics    map[string]*metricSeries
+	expired    time.Time // time of the last expiry
+	unreported []string  // metrics with refused data points and no self metric yet
+}
+
+var seriesLimiter = cardinality{metrics: make(map[string]*metricSeries)}
+
+// limit drops the data points of series seen for the first time within
+// SeriesWindow if their metric already has too many series.
+func (c *cardinality) limit(dps []*opentsdb.DataPoint) []*opentsdb.DataPoint {
+	if MaxSeries == 0 && len(SeriesLimits) == 0 {
+		return dps
+	}
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	if now.Sub(c.expired) > SeriesWindow/10 {
+		c.expire(now.Add(-SeriesWindow))
+		c.expired = now
+	}
+	out := dps[:0:0]
+	for _, dp := range dps {
+		m := c.metrics[dp.Metric]
+		if m == nil {
+			m = &metricSeries{max: seriesLimit(dp.Metric)}
+			if m.max > 0 {
+				m.series = make(map[string]time.Time)
+			}
+			c.metrics[dp.Metric] = m
+		}
+		if m.max == 0 {
+			out = append(out, dp)
+			continue
+		}
+		key := dp.Tags.String()
+		if _, ok := m.series[key]; ok || len(m.series) < m.max {
+			m.series[key] = now
+			out = append(out, dp)
+			continue
+		}
+		if !m.reported {
+			log.Errorf("cardinality: %s has more than %d series, refusing new ones such as %s", dp.Metric, m.max, key)
+			m.reported = true
+			m.refused = make(map[string]time.Time)
+			c.unreported = append(c.unreported, dp.Metric)
+		}
+		m.refused[key] = now
+		m.dropped++
+	}
+	return out
+}
+
+// expire forgets the series not seen since before.
+func (c *cardinality) expire(before time.Time) {
+	for name, m := range c.metrics {
+		for k, t := range m.series {
+			if t.Before(before) {
+				delete(m.series, k)
+			}
+		}
+		for k, t := range m.refused {
+			if t.Before(before) {
+				delete(m.refused, k)
+			}
+		}
+		if len(m.series) == 0 && !m.reported {
+			delete(c.metrics, name)
+		}
+	}
+}
+
+// report registers the self metrics counting the refused series and dropped
+// data points of every metric that started refusing series since the last
+// call. Set takes
+// the lock held by collect while it feeds the queuer, which calls limit, so
+// report must not be called from limit.
+func (c *cardinality) report() {
+	c.Lock()
+	metrics := make(map[string]*metricSeries, len(c.unreported))
+	for _, name := range c.unreported {
+		metrics[name] = c.metrics[name]
+	}
+	c.unreported = nil
+	c.Unlock()
+	for name, m := range metrics {
+		m := m
+		tags := Tags.Copy().Merge(opentsdb.TagSet{"metric": name})
+		Set("collect.cardinality.rejected", tags, func() interface{} {
+			c.Lock()
+			defer c.Unlock()
+			return len(m.refused)
+		})
+		Set("collect.cardinality.dropped", tags, func() interface{} {
+			c.Lock()
+			defer c.Unlock()
+			return m.dropped
+		})
+	}
+}
+
+func seriesLimit(metric string) int {
+	for _, l := range SeriesLimits {
+		if l.Metric.Match(metric) {
+			return l.Max
+		}
+	}
+	return MaxSeries
+}
@@ -0,0 +1,46 @@
+package collect
+
+import (
+	"testing"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestCardinalityLimit(t *testing.T) {
+	MaxSeries = 2
+	p, err := NewPattern("test.unlimited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SeriesLimits = []SeriesLimit{{Metric: p, Max: 0}}
+	defer func() {
+		MaxSeries = 0
+		SeriesLimits = nil
+	}()
+	c := cardinality{metrics: make(map[string]*metricSeries)}
+	in := []*opentsdb.DataPoint{
+		testPoint("test.procs", 1, 1, "name", "a"),
+		testPoint("test.procs", 1, 1, "name", "b"),
+		testPoint("test.procs", 1, 1, "name", "c"),
+		testPoint("test.procs", 1, 1, "name", "a"),
+		testPoint("test.procs", 1, 1, "name", "d"),
+		testPoint("test.procs", 1, 1, "name", "e"),
+		testPoint("test.procs", 1, 1, "name", "f"),
+		testPoint("test.procs", 1, 1, "name", "c"),
+		testPoint("test.unlimited", 1, 1, "name", "c"),
+	}
+	if out := c.limit(in); len(out) != 4 {
+		t.Fatalf("expected 4 data points, got %d", len(out))
+	}
+	// Rejections are counted past the limit, once per series.
+	m := c.metrics["test.procs"]
+	if n := len(m.refused); n != 4 {
+		t.Errorf("expected 4 rejected series, got %d", n)
+	}
+	if m.dropped != 5 {
+		t.Errorf("expected 5 dropped data points, got %d", m.dropped)
+	}
+	if len(c.unreported) != 1 || c.unreported[0] != "test.procs" {
+		t.Errorf("expected the self metric of test.procs to be pending, got %v", c.unreported)
+	}
+}
@@ -6,17 +6,17 @@ package collect
 
 import (
 	"fmt"
-	"math"
 	"net/http"
 	"net/url"
 	"os"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"mosun_collector/metadata"
 	"mosun_collector/opentsdb"
+	"mosun_collector/util"
 )
 
 var (
@@ -30,6 +30,35 @@ var (
 	// BatchSize is the maximum length of data points sent at once to OpenTSDB.
 	BatchSize = 500
 
+	// BatchBytes, if not zero, is the maximum size of a batch, estimated as
+	// its JSON encoding before compression.
+	BatchBytes = 0
+
+	// Senders is the number of batches each output sends concurrently.
+	// Batches are not sent in order if greater than 1.
+	Senders = 1
+
+	// FlushInterval is how long a partial batch waits for more data before
+	// it is sent. Full batches are sent at once.
+	FlushInterval = time.Second
+
+	// SpoolDir, if not empty, is the directory of the on-disk spool. Data
+	// points that do not fit in the queue are written there and sent once
+	// the queue has been drained, including after a restart.
+	SpoolDir string
+
+	// SpoolMaxBytes is the maximum size of the spool, above which the oldest
+	// spooled data is discarded. Defaults to 1GB.
+	SpoolMaxBytes int64 = 1024 * 1024 * 1024
+
+	// SpoolMaxAge is the maximum age of spooled data, above which it is
+	// discarded instead of sent.
+	SpoolMaxAge = time.Hour * 24
+
+	// DeadLetter, if not empty, is the file data points rejected by the
+	// OpenTSDB host are appended to.
+	DeadLetter string
+
 	// Print prints all datapoints to stdout instead of sending them.
 	Print = false
 
@@ -40,25 +69,18 @@ var (
 	// Tags is an opentsdb.TagSet used when sending self metrics.
 	Tags opentsdb.TagSet
 
-	// Dropped is the number of dropped data points due to a full queue.
-	dropped int64
-
-	// Sent is the number of sent data points.
-	sent int64
-
 	License string
 
-	tchan               chan *opentsdb.DataPoint
-	tsdbURL             string
-	osHostname          string
-	metricRoot          string
-	queue               []*opentsdb.DataPoint
-	qlock, mlock, slock sync.Mutex // Locks for queues, maps, stats.
-	counters            = make(map[string]*addMetric)
-	sets                = make(map[string]*setMetric)
-	puts                = make(map[string]*putMetric)
-	aggs                = make(map[string]*agMetric)
-	client              = &http.Client{
+	tchan      chan *opentsdb.DataPoint
+	osHostname string
+	metricRoot string
+	outputs    []*output
+	mlock      sync.Mutex // Lock for maps.
+	counters   = make(map[string]*addMetric)
+	sets       = make(map[string]*setMetric)
+	puts       = make(map[string]*putMetric)
+	aggs       = make(map[string]*agMetric)
+	client     = &http.Client{
 		Transport: &timeoutTransport{Transport: new(http.Transport)},
 		Timeout:   time.Minute,
 	}
@@ -66,19 +88,35 @@ var (
 
 type timeoutTransport struct {
 	*http.Transport
-	Timeout time.Time
+
+	sync.Mutex // protects Timeout: the outputs send concurrently
+	Timeout    time.Time
 }
 
 func (t *timeoutTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.Lock()
 	if time.Now().After(t.Timeout) {
 		t.Transport.CloseIdleConnections()
 		t.Timeout = time.Now().Add(time.Minute * 5)
 	}
+	t.Unlock()
 	return t.Transport.RoundTrip(r)
 }
 
+// SetTransport makes all HTTP outputs use t, adding header to every request.
+// It must be called before Init or InitChan.
+func SetTransport(t *http.Transport, header http.Header) {
+	client = &http.Client{
+		Transport: &util.HeaderTransport{
+			Transport: &timeoutTransport{Transport: t},
+			Header:    header,
+		},
+		Timeout: time.Minute,
+	}
+}
+
 // InitChan is similar to Init, but uses the given channel instead of creating a
-// new one.
+// new one. tsdbhost may be nil if other sinks were added with AddSink.
 func InitChan(tsdbhost *url.URL, root string, ch chan *opentsdb.DataPoint) error {
 	if tchan != nil {
 		return fmt.Errorf("cannot init twice")
@@ -86,22 +124,33 @@ func InitChan(tsdbhost *url.URL, root string, ch chan *opentsdb.DataPoint) error
 	if err := checkClean(root, "metric root"); err != nil {
 		return err
 	}
-	u, err := tsdbhost.Parse("/api/put")
-	if err != nil {
-		return err
+	if Print {
+		outputs = []*output{{sink: printSink{}}}
+	} else if tsdbhost != nil {
+		s, err := NewOpenTSDBSink("default", tsdbhost, DeadLetter)
+		if err != nil {
+			return err
+		}
+		if err := AddSink(s, 0, 0); err != nil {
+			return err
+		}
 	}
-	if strings.HasPrefix(u.Host, ":") {
-		u.Host = "localhost" + u.Host
+	if len(outputs) == 0 {
+		return fmt.Errorf("no output specified")
 	}
-	tsdbURL = u.String()
 	metricRoot = root + "."
+	for _, o := range outputs {
+		if err := o.start(); err != nil {
+			return err
+		}
+	}
 	tchan = ch
 	go queuer()
-	go send()
 	go collect()
 	if DisableDefaultCollectors {
 		return nil
 	}
+	initSelfMetrics()
 	return nil
 }
 
@@ -134,11 +183,33 @@ func setHostName() error {
 type agMetric struct {
 	metric string
 	ts     opentsdb.TagSet
-	values []float64
+	values *sketch
 }
 
-func AggregateMeta(metric string, unit metadata.Unit, desc string) {
-	agStrings := []string{"avg", "count", "min", "median", "max", "95", "99"}
+// defaultPercentiles are the percentiles sent for sampled metrics, in
+// addition to the average, count, minimum and maximum.
+var defaultPercentiles = []float64{.5, .95, .99}
+
+// percentiles holds the percentiles set with AggregateMeta, by metric name.
+// It is protected by mlock.
+var percentiles = make(map[string][]float64)
+
+// AggregateMeta documents the metrics sent for metric sampled with Sample:
+// metric_avg, metric_count, metric_min, metric_max and one metric per
+// percentile, between 0 and 1. The percentiles default to .5, .95 and .99,
+// sent as metric_median, metric_95 and metric_99.
+func AggregateMeta(metric string, unit metadata.Unit, desc string, pcts ...float64) {
+	if len(pcts) > 0 {
+		mlock.Lock()
+		percentiles[metric] = pcts
+		mlock.Unlock()
+	} else {
+		pcts = defaultPercentiles
+	}
+	agStrings := []string{"avg", "count", "min", "max"}
+	for _, p := range pcts {
+		agStrings = append(agStrings, percentileName(p))
+	}
 	for _, ag := range agStrings {
 		if ag == "count" {
 			metadata.AddMetricMeta(metric+"_"+ag, metadata.Gauge, metadata.Count, "The number of samples per aggregation.")
@@ -148,70 +219,56 @@ func AggregateMeta(metric string, unit metadata.Unit, desc string) {
 	}
 }
 
+// percentileName returns the metric suffix of percentile p: median for .5,
+// otherwise p as a percentage without the decimal point, e.g. 95 or 999.
+func percentileName(p float64) string {
+	if p == .5 {
+		return "median"
+	}
+	// Format with limited precision so that float errors, e.g. .29*100 =
+	// 28.999999999999996, don't show in the name.
+	s := strconv.FormatFloat(p*100, 'f', 4, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return strings.Replace(s, ".", "", 1)
+}
+
+// Process sends the aggregates of am. mlock must be held.
 func (am *agMetric) Process(now int64) {
-	var avg float64
-	for _, v := range am.values {
-		avg += v
+	if am.values.count == 0 {
+		return
 	}
-	avg /= float64(len(am.values))
 	extRoot := metricRoot + am.metric
-	tchan <- &opentsdb.DataPoint{
-		Metric:    extRoot + "_avg",
-		Timestamp: now,
-		Value:     avg,
-		Tags:      am.ts,
-	}
-	tchan <- &opentsdb.DataPoint{
-		Metric:    extRoot + "_count",
-		Timestamp: now,
-		Value:     len(am.values),
-		Tags:      am.ts,
-	}
-	sort.Float64s(am.values)
-	percentile := func(p float64) float64 {
-		if p <= 0 {
-			return am.values[0]
-		}
-		if p >= 1 {
-			return am.values[len(am.values)-1]
+	send := func(suffix string, v interface{}) {
+		tchan <- &opentsdb.DataPoint{
+			Metric:    extRoot + "_" + suffix,
+			Timestamp: now,
+			Value:     v,
+			Tags:      am.ts,
 		}
-		i := p * float64(len(am.values)-1)
-		i = math.Ceil(i)
-		return am.values[int(i)]
-	}
-	tchan <- &opentsdb.DataPoint{
-		Metric:    extRoot + "_min",
-		Timestamp: now,
-		Value:     percentile(0),
-		Tags:      am.ts,
-	}
-	tchan <- &opentsdb.DataPoint{
-		Metric:    extRoot + "_median",
-		Timestamp: now,
-		Value:     percentile(.5),
-		Tags:      am.ts,
-	}
-	tchan <- &opentsdb.DataPoint{
-		Metric:    extRoot + "_max",
-		Timestamp: now,
-		Value:     percentile(1),
-		Tags:      am.ts,
-	}
-	tchan <- &opentsdb.DataPoint{
-		Metric:    extRoot + "_95",
-		Timestamp: now,
-		Value:     percentile(.95),
-		Tags:      am.ts,
-	}
-	tchan <- &opentsdb.DataPoint{
-		Metric:    extRoot + "_99",
-		Timestamp: now,
-		Value:     percentile(.99),
-		Tags:      am.ts,
 	}
+	send("avg", am.values.sum/float64(am.values.count))
+	send("count", am.values.count)
+	send("min", am.values.min)
+	pcts, ok := percentiles[extRoot]
+	if !ok {
+		pcts = defaultPercentiles
+	}
+	for _, p := range pcts {
+		send(percentileName(p), am.values.quantile(p))
+	}
+	send("max", am.values.max)
 }
 
 func Sample(metric string, ts opentsdb.TagSet, v float64) error {
+	return SampleN(metric, ts, v, 1)
+}
+
+// SampleN is Sample with v counted n times, e.g. for a value measured on
+// one out of n events.
+func SampleN(metric string, ts opentsdb.TagSet, v float64, n int64) error {
+	if n <= 0 {
+		return nil
+	}
 	if err := check(metric, &ts); err != nil {
 		return err
 	}
@@ -221,9 +278,10 @@ func Sample(metric string, ts opentsdb.TagSet, v float64) error {
 		aggs[tss] = &agMetric{
 			metric: metric,
 			ts:     ts.Copy(),
+			values: newSketch(),
 		}
 	}
-	aggs[tss].values = append(aggs[tss].values, v)
+	aggs[tss].values.addN(v, uint64(n))
 	mlock.Unlock()
 	return nil
 }
@@ -339,7 +397,7 @@ func checkClean(s, t string) error {
 func collect() {
 	for {
 		mlock.Lock()
-		now := time.Now().Unix()
+		now := timestamp()
 		for _, c := range counters {
 			dp := &opentsdb.DataPoint{
 				Metric:    metricRoot + c.metric,
@@ -370,6 +428,9 @@ func collect() {
 		for _, am := range aggs {
 			am.Process(now)
 		}
+		for _, h := range hists {
+			h.Process(now)
+		}
 		puts = make(map[string]*putMetric)
 		aggs = make(map[string]*agMetric)
 		mlock.Unlock()
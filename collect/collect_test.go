@@ -0,0 +1,16 @@
+package collect
+
+import "mosun_collector/opentsdb"
+
+// testPoint returns a data point of metric at ts with value v. tags are
+// key, value pairs and default to host=h.
+func testPoint(metric string, ts int64, v interface{}, tags ...string) *opentsdb.DataPoint {
+	if len(tags) == 0 {
+		tags = []string{"host", "h"}
+	}
+	t := opentsdb.TagSet{}
+	for i := 0; i+1 < len(tags); i += 2 {
+		t[tags[i]] = tags[i+1]
+	}
+	return &opentsdb.DataPoint{Metric: metric, Timestamp: ts, Value: v, Tags: t}
+}
@@ -0,0 +1,110 @@
+package collect
+
+import (
+	"sync"
+	"time"
+
+	"mosun_collector/opentsdb"
+)
+
+var (
+	// Dedup enables change-only sending: data points whose value equals the
+	// last one sent for their series are dropped, except once every
+	// DedupHeartbeat.
+	Dedup bool
+
+	// DedupHeartbeat is the maximum time between two data points sent for a
+	// series whose value does not change.
+	DedupHeartbeat = time.Minute * 10
+
+	// DedupInclude and DedupExclude select the metrics deduplicated: those
+	// matching one of DedupInclude, or any if empty, and none of
+	// DedupExclude.
+	DedupInclude []*Pattern
+	DedupExclude []*Pattern
+)
+
+const descCollectDedupSuppressed = "Number of data points not sent because their value did not change."
+
+type dedupEntry struct {
+	value float64
+	sent  time.Time
+}
+
+// dedup holds the last value sent for every deduplicated series.
+type dedup struct {
+	sync.Mutex
+	last       map[string]dedupEntry
+	match      map[string]bool // whether a metric is deduplicated
+	suppressed int64
+	expired    time.Time
+}
+
+var changeOnly = dedup{
+	last:  make(map[string]dedupEntry),
+	match: make(map[string]bool),
+}
+
+// filter drops the data points of dps repeating the last value sent for
+// their series.
+func (d *dedup) filter(dps []*opentsdb.DataPoint) []*opentsdb.DataPoint {
+	if !Dedup {
+		return dps
+	}
+	d.Lock()
+	defer d.Unlock()
+	now := time.Now()
+	if now.Sub(d.expired) > DedupHeartbeat {
+		// Series not sent for two heartbeats stopped reporting.
+		for k, e := range d.last {
+			if now.Sub(e.sent) > DedupHeartbeat*2 {
+				delete(d.last, k)
+			}
+		}
+		d.expired = now
+	}
+	out := dps[:0:0]
+	for _, dp := range dps {
+		v, ok := floatValue(dp.Value)
+		if !ok || !d.dedups(dp.Metric) {
+			out = append(out, dp)
+			continue
+		}
+		key := dp.Metric + dp.Tags.String()
+		if e, ok := d.last[key]; ok && e.value == v && now.Sub(e.sent) < DedupHeartbeat {
+			d.suppressed++
+			continue
+		}
+		d.last[key] = dedupEntry{value: v, sent: now}
+		out = append(out, dp)
+	}
+	return out
+}
+
+func (d *dedup) dedups(metric string) bool {
+	m, ok := d.match[metric]
+	if ok {
+		return m
+	}
+	m = len(DedupInclude) == 0
+	for _, p := range DedupInclude {
+		if p.Match(metric) {
+			m = true
+			break
+		}
+	}
+	for _, p := range DedupExclude {
+		if p.Match(metric) {
+			m = false
+			break
+		}
+	}
+	d.match[metric] = m
+	return m
+}
+
+func (d *dedup) suppressedCount() interface{} {
+	d.Lock()
+	defer d.Unlock()
+	return d.suppressed
+}
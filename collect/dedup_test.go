@@ -0,0 +1,53 @@
+package collect
+
+import (
+	"testing"
+	"time"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestDedupFilter(t *testing.T) {
+	Dedup = true
+	p, err := NewPattern("test.changing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	DedupExclude = []*Pattern{p}
+	defer func() {
+		Dedup = false
+		DedupExclude = nil
+		DedupHeartbeat = time.Minute * 10
+	}()
+	d := dedup{last: make(map[string]dedupEntry), match: make(map[string]bool)}
+	in := func() []*opentsdb.DataPoint {
+		return []*opentsdb.DataPoint{testPoint("test.total", 1, 100), testPoint("test.changing", 1, 1)}
+	}
+	if out := d.filter(in()); len(out) != 2 {
+		t.Fatalf("expected 2 data points, got %d", len(out))
+	}
+	if out := d.filter(in()); len(out) != 1 || out[0].Metric != "test.changing" {
+		t.Fatalf("expected only the excluded metric, got %v", out)
+	}
+	if out := d.filter([]*opentsdb.DataPoint{testPoint("test.total", 1, 101)}); len(out) != 1 {
+		t.Fatalf("expected changed value to be sent")
+	}
+	DedupHeartbeat = 0
+	if out := d.filter([]*opentsdb.DataPoint{testPoint("test.total", 1, 101)}); len(out) != 1 {
+		t.Fatalf("expected heartbeat to be sent")
+	}
+}
+
+func TestExposeAge(t *testing.T) {
+	defer func() {
+		Dedup = false
+		DedupHeartbeat = time.Minute * 10
+	}()
+	if age := exposeAge(); age != exposeExpiry {
+		t.Errorf("expected %s without dedup, got %s", exposeExpiry, age)
+	}
+	Dedup, DedupHeartbeat = true, time.Minute*15
+	if age := exposeAge(); age != time.Minute*30 {
+		t.Errorf("expected twice the heartbeat, got %s", age)
+	}
+}
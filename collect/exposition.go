@@ -0,0 +1,148 @@
+package collect
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/metadata"
+	"mosun_collector/opentsdb"
+)
+
+// exposeExpiry is how long a series is exposed after its last value, unless
+// Dedup sends unchanged values less often.
+const exposeExpiry = time.Minute * 10
+
+// exposeAge returns how long a series is exposed after its last value: at
+// least twice DedupHeartbeat so that unchanged series don't flap.
+func exposeAge() time.Duration {
+	if Dedup && DedupHeartbeat*2 > exposeExpiry {
+		return DedupHeartbeat * 2
+	}
+	return exposeExpiry
+}
+
+type exposedSeries struct {
+	metric  string
+	labels  [][2]string
+	value   float64
+	updated time.Time
+}
+
+// exposition is a sink keeping the latest value of every series to serve
+// them in the Prometheus text format.
+type exposition struct {
+	sync.Mutex
+	series map[string]*exposedSeries
+}
+
+func (e *exposition) Name() string { return "metrics" }
+
+func (e *exposition) Send(batch []*opentsdb.DataPoint) error {
+	now := time.Now()
+	e.Lock()
+	defer e.Unlock()
+	for _, dp := range batch {
+		v, ok := floatValue(dp.Value)
+		if !ok {
+			continue
+		}
+		key := dp.Metric + dp.Tags.String()
+		s := e.series[key]
+		if s == nil {
+			s = &exposedSeries{
+				metric: dp.Metric,
+				labels: promLabels(dp),
+			}
+			e.series[key] = s
+		}
+		s.value = v
+		s.updated = now
+	}
+	return nil
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// ServeHTTP writes the latest value of every series in the Prometheus text
+// exposition format, using the metric metadata for # TYPE and # HELP.
+func (e *exposition) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	meta := metadata.Metrics()
+	byName := make(map[string][]*exposedSeries)
+	var names []string
+	age := exposeAge()
+	e.Lock()
+	for key, s := range e.series {
+		if time.Since(s.updated) > age {
+			delete(e.series, key)
+			continue
+		}
+		name := s.labels[0][1]
+		if byName[name] == nil {
+			names = append(names, name)
+		}
+		c := *s
+		byName[name] = append(byName[name], &c)
+	}
+	e.Unlock()
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	b := bufio.NewWriter(w)
+	for _, name := range names {
+		series := byName[name]
+		mi := meta[series[0].metric]
+		if mi.Desc != "" || mi.Unit != metadata.None {
+			help := mi.Desc
+			if mi.Unit != metadata.None {
+				help += " (" + string(mi.Unit) + ")"
+			}
+			b.WriteString("# HELP " + name + " " + helpEscaper.Replace(strings.TrimSpace(help)) + "\n")
+		}
+		typ := "gauge"
+		if mi.Rate == metadata.Counter {
+			typ = "counter"
+		}
+		b.WriteString("# TYPE " + name + " " + typ + "\n")
+		lines := make([]string, len(series))
+		for i, s := range series {
+			var l []string
+			// labels[0] is __name__.
+			for _, kv := range s.labels[1:] {
+				l = append(l, kv[0]+`="`+labelEscaper.Replace(kv[1])+`"`)
+			}
+			lines[i] = name + "{" + strings.Join(l, ",") + "} " + strconv.FormatFloat(s.value, 'g', -1, 64) + "\n"
+		}
+		sort.Strings(lines)
+		for _, l := range lines {
+			b.WriteString(l)
+		}
+	}
+	if err := b.Flush(); err != nil {
+		log.Debug(err)
+	}
+}
+
+// ServeMetrics adds an output keeping the latest value of every series and
+// serves them for Prometheus at http://addr/metrics. It must be called
+// before Init or InitChan.
+func ServeMetrics(addr string) error {
+	e := &exposition{series: make(map[string]*exposedSeries)}
+	if err := AddSink(e, 0, 0); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	go func() {
+		log.Infof("Serving metrics at http://%s/metrics", addr)
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+	return nil
+}
@@ -0,0 +1,90 @@
+package collect
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/opentsdb"
+)
+
+// Flush sends the queued data of every output until all queues are empty or
+// timeout has passed, and then stops sending. Data still queued is written
+// to the spool, if enabled, to be sent after the next start. Flush returns
+// the number of data points that were neither sent nor spooled.
+func Flush(timeout time.Duration) (lost int64) {
+	deadline := time.Now().Add(timeout)
+	for _, o := range outputs {
+		o.Lock()
+		o.closing = true
+		o.Unlock()
+		o.wake()
+	}
+	for !drained() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+	for _, o := range outputs {
+		lost += int64(o.stop())
+	}
+	return lost
+}
+
+// drained returns true if no output has data left to send.
+func drained() bool {
+	for _, o := range outputs {
+		o.Lock()
+		n := len(o.queue) + len(o.inflight)
+		o.Unlock()
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// stop stops sending and spools the unsent data of o. The batches being
+// sent, if any, are spooled as well: they may be sent twice but are not lost.
+// stop returns the number of data points that could not be spooled.
+func (o *output) stop() (lost int) {
+	o.Lock()
+	defer o.Unlock()
+	o.stopped = true
+	var rest []*opentsdb.DataPoint
+	for _, b := range o.inflight {
+		rest = append(rest, b...)
+	}
+	rest = append(rest, o.queue...)
+	o.queue = nil
+	if o.spool != nil {
+		if len(rest) > 0 {
+			lost = o.spool.write(rest)
+			o.ack(rest)
+			log.Infof("%s: spooled %d unsent data points", o.sink.Name(), len(rest)-lost)
+		}
+		o.spool.close()
+	} else {
+		lost = len(rest)
+	}
+	if lost > 0 {
+		log.Errorf("%s: %d unsent data points lost", o.sink.Name(), lost)
+	}
+	return lost
+}
+
+// Spill moves the queued data of every output with a spool to its spool to
+// free memory. Data arriving later goes to the spool as well until it has
+// been sent. Spill returns the number of data points moved.
+func Spill() (n int) {
+	for _, o := range outputs {
+		o.Lock()
+		if o.spool != nil && len(o.queue) > 0 {
+			q := o.queue
+			o.queue = nil
+			failed := o.spool.write(q)
+			o.ack(q)
+			o.drop(failed)
+			n += len(q) - failed
+		}
+		o.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,87 @@
+package collect
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mosun_collector/opentsdb"
+)
+
+type graphiteSink struct {
+	name   string
+	prefix string
+	tagged bool
+	conn   tcpConn
+	sentBytes
+}
+
+// NewGraphiteSink returns a sink streaming data points in the Graphite
+// plaintext protocol to addr (port 2003 if unspecified). The path is the
+// metric followed by the tag values sorted by tag key, e.g.
+// os.cpu.web01.user. If tagged is true Graphite 1.1 tagged series are sent
+// instead: os.cpu;host=web01;type=user. prefix, if not empty, is prepended
+// to every path.
+func NewGraphiteSink(name, addr, prefix string, tagged bool) Sink {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &graphiteSink{
+		name:   name,
+		prefix: prefix,
+		tagged: tagged,
+		conn:   tcpConn{addr: tcpAddr(addr, "2003")},
+	}
+}
+
+func (s *graphiteSink) Name() string { return s.name }
+
+func (s *graphiteSink) Send(batch []*opentsdb.DataPoint) error {
+	var buf bytes.Buffer
+	for _, dp := range batch {
+		s.writeLine(&buf, dp)
+	}
+	if err := s.conn.write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.add(buf.Len())
+	return nil
+}
+
+var (
+	graphitePathReplacer   = strings.NewReplacer(".", "_", " ", "_")
+	graphiteTaggedReplacer = strings.NewReplacer(";", "_", " ", "_", "~", "_")
+)
+
+// writeLine writes dp as a plaintext protocol line to buf. Data points
+// without a numeric value are skipped.
+func (s *graphiteSink) writeLine(buf *bytes.Buffer, dp *opentsdb.DataPoint) {
+	v, ok := floatValue(dp.Value)
+	if !ok {
+		return
+	}
+	keys := make([]string, 0, len(dp.Tags))
+	for k := range dp.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf.WriteString(s.prefix)
+	buf.WriteString(dp.Metric)
+	for _, k := range keys {
+		if s.tagged {
+			buf.WriteByte(';')
+			buf.WriteString(graphiteTaggedReplacer.Replace(k))
+			buf.WriteByte('=')
+			buf.WriteString(graphiteTaggedReplacer.Replace(dp.Tags[k]))
+		} else {
+			buf.WriteByte('.')
+			buf.WriteString(graphitePathReplacer.Replace(dp.Tags[k]))
+		}
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(timestampSeconds(dp.Timestamp), 10))
+	buf.WriteByte('\n')
+}
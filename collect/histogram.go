@@ -0,0 +1,128 @@
+package collect
+
+import (
+	"sort"
+	"strconv"
+
+	"mosun_collector/metadata"
+	"mosun_collector/opentsdb"
+)
+
+// DefaultBuckets are the bucket upper bounds of the histograms of metrics
+// without buckets set with HistogramMeta. They suit latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	// histBuckets holds the buckets set with HistogramMeta, by metric name.
+	// It is protected by mlock.
+	histBuckets = make(map[string][]float64)
+	hists       = make(map[string]*histMetric)
+)
+
+type histMetric struct {
+	metric string
+	ts     opentsdb.TagSet
+	bounds []float64
+	leTags []opentsdb.TagSet // ts with the le tag of each bucket, and inf
+	counts []uint64          // per bucket, the last one for values above all bounds
+	sum    float64
+	count  uint64
+}
+
+// HistogramMeta sets the bucket upper bounds of the histogram of metric and
+// documents its metrics. Like AggregateMeta, metric includes the root given
+// to Init. If buckets is empty, DefaultBuckets are used.
+func HistogramMeta(metric string, buckets []float64, unit metadata.Unit, desc string) {
+	if len(buckets) > 0 {
+		b := append([]float64(nil), buckets...)
+		sort.Float64s(b)
+		mlock.Lock()
+		histBuckets[metric] = b
+		mlock.Unlock()
+	}
+	histogramMeta(metric, unit, desc)
+}
+
+func histogramMeta(metric string, unit metadata.Unit, desc string) {
+	metadata.AddMetricMeta(metric+"_bucket", metadata.Counter, metadata.Count,
+		"Number of observations less than or equal to the le tag, inf counting all. "+desc)
+	metadata.AddMetricMeta(metric+"_sum", metadata.Counter, unit, "Sum of all observations. "+desc)
+	metadata.AddMetricMeta(metric+"_count", metadata.Counter, metadata.Count, "Number of observations. "+desc)
+}
+
+// Histogram counts v in the histogram of metric. Every flush sends, as
+// counters since the start of the process, metric_bucket with an le tag for
+// each bucket holding the number of values less than or equal to it,
+// metric_sum and metric_count. Unlike the percentiles of Sample, these can be
+// summed across hosts before computing quantiles. Metadata is registered on
+// first use unless HistogramMeta was called. Histogram must be called after
+// Init.
+func Histogram(metric string, ts opentsdb.TagSet, v float64) error {
+	if err := check(metric, &ts); err != nil {
+		return err
+	}
+	tss := metric + ts.String()
+	mlock.Lock()
+	defer mlock.Unlock()
+	h := hists[tss]
+	if h == nil {
+		bounds, ok := histBuckets[metricRoot+metric]
+		if !ok {
+			bounds = DefaultBuckets
+			histBuckets[metricRoot+metric] = bounds
+			histogramMeta(metricRoot+metric, metadata.None, "")
+		}
+		h = newHistMetric(metric, ts, bounds)
+		hists[tss] = h
+	}
+	h.observe(v)
+	return nil
+}
+
+func newHistMetric(metric string, ts opentsdb.TagSet, bounds []float64) *histMetric {
+	h := &histMetric{
+		metric: metric,
+		ts:     ts.Copy(),
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+	for _, b := range bounds {
+		h.leTags = append(h.leTags, ts.Copy().Merge(opentsdb.TagSet{"le": strconv.FormatFloat(b, 'f', -1, 64)}))
+	}
+	h.leTags = append(h.leTags, ts.Copy().Merge(opentsdb.TagSet{"le": "inf"}))
+	return h
+}
+
+func (h *histMetric) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// Process sends the cumulative bucket counts, sum and count of h. mlock must
+// be held.
+func (h *histMetric) Process(now int64) {
+	extRoot := metricRoot + h.metric
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		tchan <- &opentsdb.DataPoint{
+			Metric:    extRoot + "_bucket",
+			Timestamp: now,
+			Value:     n,
+			Tags:      h.leTags[i],
+		}
+	}
+	tchan <- &opentsdb.DataPoint{
+		Metric:    extRoot + "_sum",
+		Timestamp: now,
+		Value:     h.sum,
+		Tags:      h.ts,
+	}
+	tchan <- &opentsdb.DataPoint{
+		Metric:    extRoot + "_count",
+		Timestamp: now,
+		Value:     h.count,
+		Tags:      h.ts,
+	}
+}
@@ -0,0 +1,39 @@
+package collect
+
+import (
+	"fmt"
+	"testing"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestHistogramProcess(t *testing.T) {
+	h := newHistMetric("test.latency", opentsdb.TagSet{"host": "h"}, []float64{.1, 1})
+	for _, v := range []float64{.05, .1, .5, 2, 3} {
+		h.observe(v)
+	}
+	ch := make(chan *opentsdb.DataPoint, 10)
+	defer func(c chan *opentsdb.DataPoint) { tchan = c }(tchan)
+	tchan = ch
+	h.Process(1)
+	close(ch)
+	var got []string
+	for dp := range ch {
+		got = append(got, dp.Metric+dp.Tags.String()+"="+fmt.Sprint(dp.Value))
+	}
+	expected := []string{
+		"test.latency_bucket{host=h,le=0.1}=2",
+		"test.latency_bucket{host=h,le=1}=3",
+		"test.latency_bucket{host=h,le=inf}=5",
+		"test.latency_sum{host=h}=5.65",
+		"test.latency_count{host=h}=5",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package collect
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mosun_collector/opentsdb"
+)
+
+type influxSink struct {
+	name       string
+	url        string
+	field      string
+	splitField bool
+	precision  string
+	sentBytes
+}
+
+// NewInfluxDBSink returns a sink posting data points in InfluxDB line
+// protocol to u, a /write (1.x) or /api/v2/write (2.x) URL including its
+// db, org or bucket parameters. If splitField is true the metric is split at
+// its last dot into measurement and field, so os.net.bytes is written to
+// measurement os.net, field bytes. Otherwise the measurement is the metric
+// name and the value is written to field. Timestamps are written with the
+// precision parameter of u, which defaults to s, or ms if Milliseconds is
+// set.
+func NewInfluxDBSink(name string, u *url.URL, field string, splitField bool) Sink {
+	u2 := *u
+	q := u2.Query()
+	precision := q.Get("precision")
+	if precision == "" {
+		precision = "s"
+		if Milliseconds {
+			precision = "ms"
+		}
+		q.Set("precision", precision)
+		u2.RawQuery = q.Encode()
+	}
+	if field == "" {
+		field = "value"
+	}
+	return &influxSink{
+		name:       name,
+		url:        u2.String(),
+		field:      field,
+		splitField: splitField,
+		precision:  precision,
+	}
+}
+
+// influxTimestamp returns ts in the precision of s.
+func (s *influxSink) influxTimestamp(ts int64) int64 {
+	ms := timestampMillis(ts)
+	switch s.precision {
+	case "ms":
+		return ms
+	case "u", "us":
+		return ms * 1e3
+	case "n", "ns":
+		return ms * 1e6
+	case "m":
+		return ms / 60e3
+	case "h":
+		return ms / 3600e3
+	}
+	return ms / 1e3
+}
+
+func (s *influxSink) Name() string { return s.name }
+
+func (s *influxSink) Send(batch []*opentsdb.DataPoint) error {
+	var buf bytes.Buffer
+	for _, dp := range batch {
+		s.writeLine(&buf, dp)
+	}
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	return postBatch(s.name, s.url, header, buf.Bytes(), len(batch), &s.sentBytes)
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// writeLine writes dp as a line protocol line to buf. Data points without a
+// numeric value are skipped.
+func (s *influxSink) writeLine(buf *bytes.Buffer, dp *opentsdb.DataPoint) {
+	v, ok := floatValue(dp.Value)
+	if !ok {
+		return
+	}
+	measurement, field := dp.Metric, s.field
+	if i := strings.LastIndex(dp.Metric, "."); s.splitField && i > 0 && i < len(dp.Metric)-1 {
+		measurement, field = dp.Metric[:i], dp.Metric[i+1:]
+	}
+	buf.WriteString(influxMeasurementEscaper.Replace(measurement))
+	keys := make([]string, 0, len(dp.Tags))
+	for k := range dp.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		buf.WriteString(influxKeyEscaper.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(influxKeyEscaper.Replace(dp.Tags[k]))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(influxKeyEscaper.Replace(field))
+	buf.WriteByte('=')
+	buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(s.influxTimestamp(dp.Timestamp), 10))
+	buf.WriteByte('\n')
+}
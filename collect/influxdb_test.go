@@ -0,0 +1,30 @@
+package collect
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestInfluxLine(t *testing.T) {
+	u, _ := url.Parse("http://localhost:8086/write?db=test")
+	dp := &opentsdb.DataPoint{
+		Metric:    "os.net.bytes",
+		Timestamp: 1500000000,
+		Value:     int64(42),
+		Tags:      opentsdb.TagSet{"iface": "eth0", "host": "web 01"},
+	}
+	for split, expect := range map[bool]string{
+		false: "os.net.bytes,host=web\\ 01,iface=eth0 value=42 1500000000\n",
+		true:  "os.net,host=web\\ 01,iface=eth0 bytes=42 1500000000\n",
+	} {
+		s := NewInfluxDBSink("influx", u, "", split).(*influxSink)
+		var buf bytes.Buffer
+		s.writeLine(&buf, dp)
+		if got := buf.String(); got != expect {
+			t.Errorf("split %v: expected %q, got %q", split, expect, got)
+		}
+	}
+}
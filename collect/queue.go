@@ -4,107 +4,379 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"mosun_collector/opentsdb"
 )
 
+// output is the queue, spool and retry state of a single sink.
+type output struct {
+	sink        Sink
+	batchSize   int // defaults to BatchSize if zero
+	maxQueueLen int // defaults to MaxQueueLen if zero
+	tags        opentsdb.TagSet
+
+	sync.Mutex // protects queue, inflight, unacked, closing and stopped
+	queue      []*opentsdb.DataPoint
+	inflight   map[int][]*opentsdb.DataPoint // batch being sent, by sender
+	closing    bool                          // Flush was called: don't read the spool
+	stopped    bool                          // Flush is done: stop sending
+	ready      chan struct{}                 // wakes a sender up when data is queued
+	spool      *spool
+	unacked    map[*opentsdb.DataPoint]*spoolSegment // spooled data not sent yet
+	retry      retryState
+
+	slock   sync.Mutex // protects stats
+	sent    int64      // number of sent data points
+	dropped int64      // number of data points dropped due to a full queue
+}
+
+// AddSink adds a destination for all collected data. batchSize and
+// maxQueueLen default to BatchSize and MaxQueueLen if zero. It must be called
+// before Init or InitChan.
+func AddSink(s Sink, batchSize, maxQueueLen int) error {
+	if tchan != nil {
+		return fmt.Errorf("cannot add sink %s after init", s.Name())
+	}
+	if err := checkClean(s.Name(), "output name"); err != nil {
+		return err
+	}
+	for _, o := range outputs {
+		if o.sink.Name() == s.Name() {
+			return fmt.Errorf("duplicate output name %s", s.Name())
+		}
+	}
+	outputs = append(outputs, &output{
+		sink:        s,
+		batchSize:   batchSize,
+		maxQueueLen: maxQueueLen,
+	})
+	return nil
+}
+
+// start opens the spool of o, if enabled, and starts its Senders.
+func (o *output) start() error {
+	o.inflight = make(map[int][]*opentsdb.DataPoint)
+	o.ready = make(chan struct{}, 1)
+	o.tags = Tags.Copy().Merge(opentsdb.TagSet{"output": o.sink.Name()})
+	if SpoolDir != "" {
+		sp, err := openSpool(filepath.Join(SpoolDir, o.sink.Name()), SpoolMaxBytes, SpoolMaxAge)
+		if err != nil {
+			return err
+		}
+		sp.dropped = o.drop
+		o.spool = sp
+	}
+	n := Senders
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		go o.send(i)
+	}
+	return nil
+}
+
+func (o *output) getBatchSize() int {
+	if o.batchSize > 0 {
+		return o.batchSize
+	}
+	return BatchSize
+}
+
+func (o *output) getMaxQueueLen() int {
+	if o.maxQueueLen > 0 {
+		return o.maxQueueLen
+	}
+	return MaxQueueLen
+}
+
 func queuer() {
 	for dp := range tchan {
-		qlock.Lock()
+		dps := []*opentsdb.DataPoint{dp}
 		for {
-			if len(queue) > MaxQueueLen {
-				slock.Lock()
-				dropped++
-				slock.Unlock()
-				break
-			}
-			queue = append(queue, dp)
 			select {
 			case dp = <-tchan:
+				dps = append(dps, dp)
 				continue
 			default:
 			}
 			break
 		}
-		qlock.Unlock()
+		dps = Relabel(RelabelRules, dps)
+		dps = seriesLimiter.limit(dps)
+		dps = counterRates.convert(dps)
+		dps = changeOnly.filter(dps)
+		dps = cleanAll(dps)
+		for _, o := range outputs {
+			o.enqueue(dps)
+		}
+	}
+}
+
+// cleanAll cleans dps in place, dropping the data points that can't be
+// cleaned. The outputs share the data points, so they must be cleaned before
+// the fan-out: MarshalJSON would otherwise modify them while other outputs
+// read them.
+func cleanAll(dps []*opentsdb.DataPoint) []*opentsdb.DataPoint {
+	out := dps[:0]
+	for _, dp := range dps {
+		if err := dp.Clean(); err != nil {
+			log.Errorf("dropping invalid data point %s%s: %v", dp.Metric, dp.Tags, err)
+			continue
+		}
+		out = append(out, dp)
+	}
+	return out
+}
+
+// enqueue appends dps to the queue of o. Data that does not fit goes to the
+// spool or is dropped if there is no spool.
+func (o *output) enqueue(dps []*opentsdb.DataPoint) {
+	o.Lock()
+	defer o.Unlock()
+	max := o.getMaxQueueLen()
+	// Once anything is spooled, new data goes to the spool as well so that it
+	// is sent in order.
+	spooling := o.spool != nil && (o.stopped || o.spool.pending())
+	var overflow []*opentsdb.DataPoint
+	for _, dp := range dps {
+		if !spooling && !o.stopped && len(o.queue) <= max {
+			o.queue = append(o.queue, dp)
+		} else if o.spool != nil {
+			spooling = true
+			overflow = append(overflow, dp)
+		} else {
+			o.drop(1)
+		}
+	}
+	if len(overflow) > 0 {
+		o.drop(o.spool.write(overflow))
+	}
+	o.wake()
+}
+
+// wake wakes up a waiting sender, if any, to check whether a full batch is
+// queued.
+func (o *output) wake() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+// spoolSegment is a spool segment read into the queue.
+type spoolSegment struct {
+	name string
+	left int // number of data points not sent yet
+}
+
+// unspool moves the oldest spooled data into the queue once the queue has
+// been drained. The segment stays in the spool until all of its data points
+// have been acked. o must be locked.
+func (o *output) unspool() {
+	if o.spool == nil || len(o.queue) >= o.getBatchSize() || !o.spool.pending() {
+		return
+	}
+	name, dps, err := o.spool.read()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if len(dps) == 0 {
+		return
+	}
+	if o.unacked == nil {
+		o.unacked = make(map[*opentsdb.DataPoint]*spoolSegment)
+	}
+	seg := &spoolSegment{name: name, left: len(dps)}
+	for _, dp := range dps {
+		o.unacked[dp] = seg
+	}
+	o.queue = append(o.queue, dps...)
+}
+
+// ack records that dps have left the queue, sent, discarded or spooled
+// again, and removes the spool segments none of the data of which is left.
+// o must be locked.
+func (o *output) ack(dps []*opentsdb.DataPoint) {
+	if len(o.unacked) == 0 {
+		return
+	}
+	for _, dp := range dps {
+		seg, ok := o.unacked[dp]
+		if !ok {
+			continue
+		}
+		delete(o.unacked, dp)
+		if seg.left--; seg.left == 0 {
+			o.spool.ack(seg.name)
+		}
 	}
 }
 
-func send() {
+// send is the loop of a sender, with the given id. Full batches are sent as
+// soon as they are queued; a partial batch waits for up to FlushInterval. A
+// batch that failed is retried by the same sender until it is sent or
+// discarded.
+func (o *output) send(id int) {
+	flush := false
+	var sending []*opentsdb.DataPoint // batch being sent or retried
+	var tries batchRetry              // failed attempts to send it
 	for {
-		qlock.Lock()
-		if i := len(queue); i > 0 {
-			if i > BatchSize {
-				i = BatchSize
+		o.Lock()
+		if o.stopped {
+			o.Unlock()
+			return
+		}
+		if sending == nil {
+			if !o.closing {
+				o.unspool()
 			}
-			sending := queue[:i]
-			queue = queue[i:]
-			log.Debugf("sending: %d, remaining: %d", i, len(queue))
-			qlock.Unlock()
-			Sample("collect.post.batchsize", Tags, float64(len(sending)))
-			sendBatch(sending)
-		} else {
-			qlock.Unlock()
-			time.Sleep(time.Second)
+			sending = o.batch(flush || o.closing)
+			if sending == nil {
+				o.Unlock()
+				select {
+				case <-o.ready:
+					flush = false
+				case <-time.After(FlushInterval):
+					flush = true
+				}
+				continue
+			}
+			if wait := o.retry.attempt(); wait > 0 {
+				// The circuit breaker is open: put the batch back.
+				o.queue = append(sending, o.queue...)
+				sending = nil
+				o.Unlock()
+				time.Sleep(wait)
+				continue
+			}
+			tries = batchRetry{}
+			o.inflight[id] = sending
+			log.Debugf("%s: sending: %d, remaining: %d", o.sink.Name(), len(sending), len(o.queue))
+			if len(o.queue) >= o.getBatchSize() {
+				// Let another sender take the next batch.
+				o.wake()
+			}
+		} else if wait := o.retry.attempt(); wait > 0 {
+			o.Unlock()
+			time.Sleep(wait)
+			continue
+		}
+		o.Unlock()
+		if !DisableDefaultCollectors {
+			Sample("collect.post.batchsize", o.tags, float64(len(sending)))
+		}
+		if o.sendBatch(sending) {
+			o.retry.success()
+			o.done(id, sending)
+			sending = nil
+			continue
+		}
+		wait, discard := o.retry.failure(&tries, len(sending))
+		if discard {
+			log.Errorf("%s: discarding %d data points: retry limit reached", o.sink.Name(), len(sending))
+			o.done(id, sending)
+			sending = nil
+			continue
 		}
+		log.Infof("%s: retrying %d data points in %s", o.sink.Name(), len(sending), wait)
+		time.Sleep(wait)
 	}
 }
 
-func sendBatch(batch []*opentsdb.DataPoint) {
-	if Print {
-		for _, d := range batch {
-			j, err := d.MarshalJSON()
-			if err != nil {
-				log.Error(err)
+// done removes the batch of sender id, sent or discarded, from the inflight
+// batches.
+func (o *output) done(id int, batch []*opentsdb.DataPoint) {
+	o.Lock()
+	delete(o.inflight, id)
+	o.ack(batch)
+	o.Unlock()
+}
+
+// batch removes the next batch from the queue and returns it. A batch holds
+// up to the batch size of o and, if BatchBytes is set, up to BatchBytes of
+// encoded data. Unless force is true, nil is returned if the queue does not
+// hold a full batch. o must be locked.
+func (o *output) batch(force bool) []*opentsdb.DataPoint {
+	max := o.getBatchSize()
+	n, size := 0, 0
+	full := false
+	for ; n < len(o.queue); n++ {
+		if n == max {
+			full = true
+			break
+		}
+		if BatchBytes > 0 {
+			s := encodedSize(o.queue[n])
+			if n > 0 && size+s > BatchBytes {
+				full = true
+				break
 			}
-			log.Info(string(j))
+			size += s
 		}
-		recordSent(len(batch))
-		return
 	}
-	now := time.Now()
-	resp, err := SendDataPoints(batch, tsdbURL)
-	if err == nil {
-		defer resp.Body.Close()
+	if n == 0 || !full && !force {
+		return nil
+	}
+	b := o.queue[:n:n]
+	o.queue = o.queue[n:]
+	return b
+}
+
+// encodedSize estimates the size of dp encoded in JSON, before compression.
+func encodedSize(dp *opentsdb.DataPoint) int {
+	n := len(dp.Metric) + 64 // field names, timestamp and value
+	for k, v := range dp.Tags {
+		n += len(k) + len(v) + 6
 	}
+	return n
+}
+
+// sendBatch sends batch and returns whether it was accepted.
+func (o *output) sendBatch(batch []*opentsdb.DataPoint) bool {
+	now := time.Now()
+	err := o.sink.Send(batch)
 	d := time.Since(now).Nanoseconds() / 1e6
-	Sample("collect.post.duration", Tags, float64(d))
+	if !DisableDefaultCollectors {
+		Sample("collect.post.duration", o.tags, float64(d))
+		Add("collect.post.count", o.tags, 1)
+	}
+	if e, ok := err.(*rejectedError); ok {
+		log.Errorf("%s: %v", o.sink.Name(), e)
+		o.drop(e.n)
+		o.recordSent(len(batch) - e.n)
+		return true
+	}
 	// Some problem with connecting to the server; retry later.
-	if err != nil || resp.StatusCode != http.StatusNoContent {
-		if err != nil {
-			log.Error(err)
-		} else if resp.StatusCode != http.StatusNoContent {
-			log.Errorln(resp.Status)
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Error(err)
-			}
-			if len(body) > 0 {
-				log.Error(string(body))
-			}
-		}
-		restored := 0
-		for _, msg := range batch { //发送失败后，重新发
-			restored++
-			tchan <- msg
+	if err != nil {
+		log.Errorf("%s: %v", o.sink.Name(), err)
+		if !DisableDefaultCollectors {
+			Add("collect.post.error", o.tags, 1)
 		}
-		d := time.Second * 5
-		log.Infof("restored %d, sleeping %s", restored, d)
-		time.Sleep(d)
-		return
+		return false
 	}
-	recordSent(len(batch))
+	o.recordSent(len(batch))
+	return true
+}
+
+func (o *output) recordSent(num int) {
+	log.Debug(o.sink.Name(), " sent ", num)
+	o.slock.Lock()
+	o.sent += int64(num)
+	o.slock.Unlock()
 }
 
-func recordSent(num int) {
-	log.Debug("sent", num)
-	slock.Lock()
-	sent += int64(num)
-	slock.Unlock()
+func (o *output) drop(num int) {
+	o.slock.Lock()
+	o.dropped += int64(num)
+	o.slock.Unlock()
 }
 
 func SendDataPoints(dps []*opentsdb.DataPoint, tsdb string) (*http.Response, error) {
@@ -0,0 +1,137 @@
+package collect
+
+import (
+	"math"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/metadata"
+	"mosun_collector/opentsdb"
+)
+
+// RateRule converts the counters whose metric matches Metric to per-second
+// rates before they are sent.
+type RateRule struct {
+	Metric *Pattern
+	// Max is the value after which the counter wraps around to zero. If zero
+	// it is 2^32-1 for values that fit in 32 bits and 2^64-1 otherwise.
+	Max float64
+	// Suffix is appended to the name of the converted metric and must not be
+	// empty.
+	Suffix string
+	// Keep also sends the counter.
+	Keep bool
+}
+
+// RateRules are applied to every data point, the first matching rule
+// winning. They must be set before Init or InitChan.
+var RateRules []RateRule
+
+// rateStaleAge is how long the previous sample of a series is kept after it
+// stopped reporting.
+const rateStaleAge = 60 * 60
+
+type rateSample struct {
+	ts    int64
+	value float64
+}
+
+// rates holds the previous sample of every converted series.
+type rates struct {
+	sync.Mutex
+	prev  map[string]rateSample
+	clean int64 // time of the last removal of stale series
+}
+
+var counterRates = rates{prev: make(map[string]rateSample)}
+
+// convert applies RateRules to dps. The first sample of a series, samples
+// not newer than the previous one and samples following a counter reset
+// only record the counter and produce no rate.
+func (r *rates) convert(dps []*opentsdb.DataPoint) []*opentsdb.DataPoint {
+	if len(RateRules) == 0 {
+		return dps
+	}
+	r.Lock()
+	defer r.Unlock()
+	out := dps[:0:0]
+	for _, dp := range dps {
+		rule := matchRate(dp.Metric)
+		if rule == nil {
+			out = append(out, dp)
+			continue
+		}
+		if rule.Keep {
+			out = append(out, dp)
+		}
+		v, ok := floatValue(dp.Value)
+		if !ok {
+			log.Errorf("rate: %s: non-numeric value %v", dp.Metric, dp.Value)
+			continue
+		}
+		key := dp.Metric + dp.Tags.String()
+		prev, seen := r.prev[key]
+		if seen && timestampMillis(dp.Timestamp) <= timestampMillis(prev.ts) {
+			continue
+		}
+		r.prev[key] = rateSample{dp.Timestamp, v}
+		if !seen {
+			metadata.AddMeta(dp.Metric+rule.Suffix, nil, "rate", metadata.Rate, false)
+			continue
+		}
+		delta, ok := counterDelta(prev.value, v, rule.Max)
+		if !ok {
+			log.Debugf("rate: %s%s reset from %v to %v", dp.Metric, dp.Tags, prev.value, v)
+			continue
+		}
+		out = append(out, &opentsdb.DataPoint{
+			Metric:    dp.Metric + rule.Suffix,
+			Timestamp: dp.Timestamp,
+			Value:     delta * 1000 / float64(timestampMillis(dp.Timestamp)-timestampMillis(prev.ts)),
+			Tags:      dp.Tags,
+		})
+	}
+	if len(dps) > 0 && timestampSeconds(dps[0].Timestamp)-r.clean > rateStaleAge {
+		r.expire(timestampSeconds(dps[0].Timestamp) - rateStaleAge)
+	}
+	return out
+}
+
+// expire removes the series that did not report since before, in seconds.
+func (r *rates) expire(before int64) {
+	for k, s := range r.prev {
+		if timestampSeconds(s.ts) < before {
+			delete(r.prev, k)
+		}
+	}
+	r.clean = before + rateStaleAge
+}
+
+func matchRate(metric string) *RateRule {
+	for i := range RateRules {
+		if RateRules[i].Metric.Match(metric) {
+			return &RateRules[i]
+		}
+	}
+	return nil
+}
+
+// counterDelta returns the increase of a counter from prev to cur. A counter
+// that went down either wrapped around max or was reset, e.g. because the
+// process or host restarted. It is considered wrapped if prev was in the
+// upper half of its range and cur in the lower half; ok is false for a reset.
+func counterDelta(prev, cur, max float64) (delta float64, ok bool) {
+	if cur >= prev {
+		return cur - prev, true
+	}
+	if max == 0 {
+		max = math.MaxUint32
+		if prev > max {
+			max = math.MaxUint64
+		}
+	}
+	if prev > max/2 && cur < max/2 && prev <= max {
+		return max - prev + cur + 1, true
+	}
+	return 0, false
+}
@@ -0,0 +1,67 @@
+package collect
+
+import (
+	"math"
+	"testing"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		prev, cur, max float64
+		delta          float64
+		ok             bool
+	}{
+		{10, 25, 0, 15, true},
+		{math.MaxUint32 - 5, 4, 0, 10, true},
+		{math.MaxUint32 + 100, 50, 0, 0, false},
+		{1000, 10, 0, 0, false},
+		{250, 5, 255, 11, true},
+	}
+	for _, test := range tests {
+		delta, ok := counterDelta(test.prev, test.cur, test.max)
+		if delta != test.delta || ok != test.ok {
+			t.Errorf("counterDelta(%v, %v, %v): expected %v %v, got %v %v", test.prev, test.cur, test.max, test.delta, test.ok, delta, ok)
+		}
+	}
+}
+
+func TestRateConvert(t *testing.T) {
+	p, err := NewPattern("test.bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RateRules = []RateRule{{Metric: p, Suffix: ".rate", Keep: true}}
+	defer func() { RateRules = nil }()
+	r := rates{prev: make(map[string]rateSample)}
+	if out := r.convert([]*opentsdb.DataPoint{testPoint("test.bytes", 100, 1000), testPoint("test.bytes_in", 100, 1)}); len(out) != 2 {
+		t.Fatalf("expected counter and other metric, got %d data points", len(out))
+	}
+	if out := r.convert([]*opentsdb.DataPoint{testPoint("test.bytes_in", 110, 2)}); len(out) != 1 {
+		t.Fatalf("expected no rate for a metric the pattern doesn't match, got %v", out)
+	}
+	out := r.convert([]*opentsdb.DataPoint{testPoint("test.bytes", 110, 1500)})
+	if len(out) != 2 || out[1].Metric != "test.bytes.rate" || out[1].Value != 50.0 {
+		t.Fatalf("expected counter and rate 50, got %v", out)
+	}
+	// A reset yields no rate.
+	if out := r.convert([]*opentsdb.DataPoint{testPoint("test.bytes", 120, 20)}); len(out) != 1 {
+		t.Fatalf("expected counter only after reset, got %v", out)
+	}
+}
+
+func TestRateMilliseconds(t *testing.T) {
+	p, err := NewPattern("test.bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RateRules = []RateRule{{Metric: p}}
+	defer func() { RateRules = nil }()
+	r := rates{prev: make(map[string]rateSample)}
+	r.convert([]*opentsdb.DataPoint{testPoint("test.bytes", 1500000000000, 0)})
+	out := r.convert([]*opentsdb.DataPoint{testPoint("test.bytes", 1500000000500, 100)})
+	if len(out) != 1 || out[0].Value != 200.0 {
+		t.Fatalf("expected rate 200, got %v", out)
+	}
+}
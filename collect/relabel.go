@@ -0,0 +1,145 @@
+package collect
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/opentsdb"
+)
+
+// Relabel actions.
+const (
+	RelabelDrop    = "drop"    // drop the matching data points
+	RelabelKeep    = "keep"    // drop the data points not matching
+	RelabelRename  = "rename"  // set the metric name to Value
+	RelabelSetTag  = "settag"  // set Tag to Value
+	RelabelDelTag  = "deltag"  // delete Tag
+	RelabelReplace = "replace" // replace Regex by Value in Tag, or the metric name if Tag is empty
+)
+
+// Pattern matches strings with a glob, e.g. os.cpu.*, or with a regular
+// expression between slashes, e.g. /^os\.(cpu|mem)\./.
+type Pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// NewPattern parses s as a glob or, between slashes, a regular expression.
+func NewPattern(s string) (*Pattern, error) {
+	if len(s) > 1 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		return &Pattern{re: re}, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return nil, fmt.Errorf("%s: %v", s, err)
+	}
+	return &Pattern{glob: s}, nil
+}
+
+// Match returns whether s matches p.
+func (p *Pattern) Match(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	ok, _ := path.Match(p.glob, s)
+	return ok
+}
+
+// RelabelRule filters or rewrites the data points it matches.
+type RelabelRule struct {
+	// Metric matches the metric name. A nil Metric matches every metric.
+	Metric *Pattern
+	// Tags match tag values. A data point missing one of the tags does not
+	// match.
+	Tags map[string]*Pattern
+
+	Action string
+	Tag    string
+	Value  string
+	Regex  *regexp.Regexp // for RelabelReplace
+}
+
+func (r *RelabelRule) match(dp *opentsdb.DataPoint) bool {
+	if r.Metric != nil && !r.Metric.Match(dp.Metric) {
+		return false
+	}
+	for k, p := range r.Tags {
+		v, ok := dp.Tags[k]
+		if !ok || !p.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// RelabelRules are applied in order to every data point before it is
+// queued. They must be set before Init or InitChan.
+var RelabelRules []RelabelRule
+
+// Relabel applies rules to dps and returns the data points left. Data points
+// are copied before being modified.
+func Relabel(rules []RelabelRule, dps []*opentsdb.DataPoint) []*opentsdb.DataPoint {
+	if len(rules) == 0 {
+		return dps
+	}
+	out := dps[:0:0]
+	for _, dp := range dps {
+		if dp = relabel(rules, dp); dp != nil {
+			out = append(out, dp)
+		}
+	}
+	return out
+}
+
+func relabel(rules []RelabelRule, dp *opentsdb.DataPoint) *opentsdb.DataPoint {
+	copied := false
+	for i := range rules {
+		r := &rules[i]
+		matched := r.match(dp)
+		if r.Action == RelabelKeep {
+			if !matched {
+				return nil
+			}
+			continue
+		}
+		if !matched {
+			continue
+		}
+		if r.Action == RelabelDrop {
+			return nil
+		}
+		if !copied {
+			c := *dp
+			c.Tags = dp.Tags.Copy()
+			dp = &c
+			copied = true
+		}
+		switch r.Action {
+		case RelabelRename:
+			dp.Metric = r.Value
+		case RelabelSetTag:
+			dp.Tags[r.Tag] = r.Value
+		case RelabelDelTag:
+			delete(dp.Tags, r.Tag)
+		case RelabelReplace:
+			if r.Tag == "" {
+				dp.Metric = r.Regex.ReplaceAllString(dp.Metric, r.Value)
+			} else if v, ok := dp.Tags[r.Tag]; ok {
+				dp.Tags[r.Tag] = r.Regex.ReplaceAllString(v, r.Value)
+			}
+		}
+	}
+	if copied {
+		if err := checkClean(dp.Metric, "metric"); err != nil || !dp.Tags.Valid() {
+			log.Errorf("relabel: dropping invalid data point %s%s", dp.Metric, dp.Tags)
+			return nil
+		}
+	}
+	return dp
+}
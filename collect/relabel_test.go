@@ -0,0 +1,50 @@
+package collect
+
+import (
+	"regexp"
+	"testing"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestRelabel(t *testing.T) {
+	pattern := func(s string) *Pattern {
+		p, err := NewPattern(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	rules := []RelabelRule{
+		{Metric: pattern("os.net.*"), Tags: map[string]*Pattern{"iface": pattern("/^veth/")}, Action: RelabelDrop},
+		{Metric: pattern("os.*"), Action: RelabelKeep},
+		{Metric: pattern("os.cpu"), Action: RelabelRename, Value: "os.cpu.total"},
+		{Action: RelabelReplace, Tag: "host", Regex: regexp.MustCompile(`\..*`), Value: ""},
+		{Action: RelabelSetTag, Tag: "dc", Value: "eu1"},
+		{Metric: pattern("os.mem.*"), Action: RelabelDelTag, Tag: "dc"},
+	}
+	in := []*opentsdb.DataPoint{
+		testPoint("os.net.bytes", 1, 1, "host", "web01.example.com", "iface", "veth12"),
+		testPoint("os.net.bytes", 1, 1, "host", "web01.example.com", "iface", "eth0"),
+		testPoint("app.requests", 1, 1, "host", "web01"),
+		testPoint("os.cpu", 1, 1, "host", "web01"),
+		testPoint("os.mem.free", 1, 1, "host", "web01"),
+	}
+	out := Relabel(rules, in)
+	expected := []string{
+		"os.net.bytes{dc=eu1,host=web01,iface=eth0}",
+		"os.cpu.total{dc=eu1,host=web01}",
+		"os.mem.free{host=web01}",
+	}
+	if len(out) != len(expected) {
+		t.Fatalf("expected %d data points, got %d", len(expected), len(out))
+	}
+	for i, d := range out {
+		if s := d.Metric + d.Tags.String(); s != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], s)
+		}
+	}
+	if in[1].Tags["host"] != "web01.example.com" {
+		t.Errorf("input data point was modified")
+	}
+}
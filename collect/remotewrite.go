@@ -0,0 +1,138 @@
+package collect
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"mosun_collector/opentsdb"
+)
+
+// RemoteWriteStaleness is how long Prometheus considers the last sample of a
+// series current. DedupHeartbeat must be shorter for remote_write outputs.
+const RemoteWriteStaleness = time.Minute * 5
+
+type remoteWriteSink struct {
+	name string
+	url  string
+	sentBytes
+}
+
+// NewRemoteWriteSink returns a sink sending data points to a Prometheus
+// remote_write endpoint, e.g. http://prometheus:9090/api/v1/write. Metric
+// names have dots replaced by underscores and tags become labels.
+func NewRemoteWriteSink(name string, u *url.URL) Sink {
+	return &remoteWriteSink{name: name, url: u.String()}
+}
+
+func (s *remoteWriteSink) Name() string { return s.name }
+
+func (s *remoteWriteSink) Send(batch []*opentsdb.DataPoint) error {
+	b := snappy.Encode(nil, encodeWriteRequest(batch))
+	header := http.Header{
+		"Content-Type":                      {"application/x-protobuf"},
+		"Content-Encoding":                  {"snappy"},
+		"X-Prometheus-Remote-Write-Version": {"0.1.0"},
+	}
+	return postBatch(s.name, s.url, header, b, len(batch), &s.sentBytes)
+}
+
+// encodeWriteRequest encodes batch as a remote_write prompb.WriteRequest.
+// Data points without a numeric value are skipped.
+func encodeWriteRequest(batch []*opentsdb.DataPoint) []byte {
+	var req, ts, buf []byte
+	for _, dp := range batch {
+		v, ok := floatValue(dp.Value)
+		if !ok {
+			continue
+		}
+		ts = ts[:0]
+		for _, l := range promLabels(dp) {
+			buf = buf[:0]
+			buf = appendString(buf, 1, l[0])
+			buf = appendString(buf, 2, l[1])
+			ts = appendBytes(ts, 1, buf)
+		}
+		buf = buf[:0]
+		buf = appendTag(buf, 1, 1) // fixed64
+		var f [8]byte
+		binary.LittleEndian.PutUint64(f[:], math.Float64bits(v))
+		buf = append(buf, f[:]...)
+		buf = appendTag(buf, 2, 0) // varint
+		buf = appendVarint(buf, uint64(timestampMillis(dp.Timestamp)))
+		ts = appendBytes(ts, 2, buf)
+		req = appendBytes(req, 1, ts)
+	}
+	return req
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, 2)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	b = appendTag(b, field, 2)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// promLabels returns the name and tags of dp as Prometheus labels, sorted by
+// label name.
+func promLabels(dp *opentsdb.DataPoint) [][2]string {
+	labels := make([][2]string, 0, len(dp.Tags)+1)
+	labels = append(labels, [2]string{"__name__", promName(dp.Metric)})
+	for k, v := range dp.Tags {
+		labels = append(labels, [2]string{promName(k), v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+	return labels
+}
+
+// promName converts an OpenTSDB metric or tag key to a valid Prometheus
+// metric or label name: every character other than a to z, A to Z, 0 to 9
+// and _ is replaced by _.
+func promName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// floatValue converts a data point value to a float64.
+func floatValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+	return f, err == nil
+}
@@ -0,0 +1,148 @@
+package collect
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+	"mosun_collector/opentsdb"
+)
+
+func TestPromLabels(t *testing.T) {
+	dp := &opentsdb.DataPoint{
+		Metric: "os.net.bytes",
+		Tags:   opentsdb.TagSet{"host": "web01", "iface": "eth0", "0dir-x": "in"},
+	}
+	expect := [][2]string{
+		{"__name__", "os_net_bytes"},
+		{"_dir_x", "in"},
+		{"host", "web01"},
+		{"iface", "eth0"},
+	}
+	if got := promLabels(dp); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+// protoField is a decoded protobuf field: v holds varint and fixed64
+// values, b length-delimited ones.
+type protoField struct {
+	num int
+	v   uint64
+	b   []byte
+}
+
+// decodeProto decodes the fields of the protobuf message b.
+func decodeProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("bad key")
+		}
+		b = b[n:]
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			if f.v, n = binary.Uvarint(b); n <= 0 {
+				return nil, fmt.Errorf("field %d: bad varint", f.num)
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("field %d: short fixed64", f.num)
+			}
+			f.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, fmt.Errorf("field %d: bad length", f.num)
+			}
+			f.b, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return nil, fmt.Errorf("field %d: unexpected wire type %d", f.num, key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// decodeWriteRequest decodes a prompb.WriteRequest into one string per
+// sample: its labels, value and timestamp.
+func decodeWriteRequest(b []byte) ([]string, error) {
+	req, err := decodeProto(b)
+	if err != nil {
+		return nil, err
+	}
+	var series []string
+	for _, ts := range req {
+		if ts.num != 1 {
+			return nil, fmt.Errorf("WriteRequest: unexpected field %d", ts.num)
+		}
+		fields, err := decodeProto(ts.b)
+		if err != nil {
+			return nil, err
+		}
+		var s string
+		for _, f := range fields {
+			m, err := decodeProto(f.b)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case f.num == 1 && len(m) == 2 && m[0].num == 1 && m[1].num == 2:
+				s += fmt.Sprintf("%s=%s ", m[0].b, m[1].b)
+			case f.num == 2 && len(m) == 2 && m[0].num == 1 && m[1].num == 2:
+				s += fmt.Sprintf("%v@%d", math.Float64frombits(m[0].v), int64(m[1].v))
+			default:
+				return nil, fmt.Errorf("TimeSeries: unexpected field %d", f.num)
+			}
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		var err error
+		if body, err = snappy.Decode(nil, b); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	s := NewRemoteWriteSink("test", u)
+	batch := []*opentsdb.DataPoint{
+		testPoint("os.cpu", 1500000000, 1.5),
+		testPoint("os.mem", 1500000000123, 300, "host", "h", "type", "free"),
+		testPoint("os.bad", 1500000000, "x"),
+	}
+	if err := s.Send(batch); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeWriteRequest(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"__name__=os_cpu host=h 1.5@1500000000000",
+		"__name__=os_mem host=h type=free 300@1500000000123",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
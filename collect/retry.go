@@ -0,0 +1,145 @@
+package collect
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"mosun_collector/metadata"
+)
+
+var (
+	// RetryMinBackoff is the delay after the first failed send.
+	RetryMinBackoff = time.Second
+
+	// RetryMaxBackoff is the maximum delay between two attempts to send the
+	// same batch.
+	RetryMaxBackoff = time.Minute * 2
+
+	// MaxRetries is the number of times a failed batch is retried before it
+	// is discarded. Zero retries forever.
+	MaxRetries = 0
+
+	// MaxRetryAge is how long a failed batch is retried before it is
+	// discarded. Zero retries forever.
+	MaxRetryAge time.Duration
+
+	// BreakerThreshold is the number of consecutive failures after which the
+	// circuit breaker opens. An open breaker holds back all sends until the
+	// backoff has passed and then lets a single trial batch through.
+	BreakerThreshold = 5
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// retryState is the retry state shared by the senders of an output: the
+// backoff and the circuit breaker. The attempts to send each batch are
+// tracked by its sender in a batchRetry.
+type retryState struct {
+	sync.Mutex
+	failures  int // consecutive failed attempts, by any sender
+	backoff   time.Duration
+	breaker   int
+	openUntil time.Time // end of the cooldown of an open breaker
+	retries   int64     // total number of retried batches
+	abandoned int64     // total number of data points discarded after retrying
+}
+
+// batchRetry tracks the failed attempts to send one batch.
+type batchRetry struct {
+	failures int       // failed attempts
+	first    time.Time // time of the first failed attempt
+}
+
+// attempt is called before a batch is sent. It returns how long to wait
+// before trying again if the breaker does not let the batch through. Once the
+// cooldown of an open breaker has passed, the breaker moves to half-open and
+// the next batch is a trial: no other batch is sent until it is done.
+func (r *retryState) attempt() time.Duration {
+	r.Lock()
+	defer r.Unlock()
+	switch r.breaker {
+	case breakerOpen:
+		if wait := r.openUntil.Sub(time.Now()); wait > 0 {
+			return wait
+		}
+		r.breaker = breakerHalfOpen
+	case breakerHalfOpen:
+		return RetryMinBackoff
+	}
+	return 0
+}
+
+// success resets the retry state after a batch was sent.
+func (r *retryState) success() {
+	r.Lock()
+	r.failures = 0
+	r.backoff = 0
+	r.breaker = breakerClosed
+	r.openUntil = time.Time{}
+	r.Unlock()
+}
+
+// failure records a failed attempt to send b, a batch of n data points. It
+// returns how long to wait before the next attempt and whether the batch
+// must be discarded instead of retried.
+func (r *retryState) failure(b *batchRetry, n int) (wait time.Duration, discard bool) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	if b.failures == 0 {
+		b.first = now
+	}
+	b.failures++
+	r.failures++
+	if (MaxRetries > 0 && b.failures > MaxRetries) || (MaxRetryAge > 0 && now.Sub(b.first) > MaxRetryAge) {
+		discard = true
+		r.abandoned += int64(n)
+	} else {
+		r.retries++
+	}
+	if r.backoff == 0 {
+		r.backoff = RetryMinBackoff
+	} else if r.backoff *= 2; r.backoff > RetryMaxBackoff {
+		r.backoff = RetryMaxBackoff
+	}
+	// Equal jitter: wait between half and all of the backoff so that agents
+	// don't retry in lockstep after an outage.
+	wait = r.backoff/2 + time.Duration(rand.Int63n(int64(r.backoff/2)+1))
+	if r.breaker == breakerHalfOpen || (BreakerThreshold > 0 && r.failures >= BreakerThreshold) {
+		r.breaker = breakerOpen
+		r.openUntil = now.Add(wait)
+	}
+	return wait, discard
+}
+
+func (r *retryState) get(f func(r *retryState) interface{}) func() interface{} {
+	return func() interface{} {
+		r.Lock()
+		defer r.Unlock()
+		return f(r)
+	}
+}
+
+func initRetryMetrics(o *output) {
+	r := &o.retry
+	metadata.AddMetricMeta(metricRoot+"collect.retry.retries", metadata.Counter, metadata.Retry,
+		"Number of times a batch was retried after a failed send.")
+	Set("collect.retry.retries", o.tags, r.get(func(r *retryState) interface{} { return r.retries }))
+	metadata.AddMetricMeta(metricRoot+"collect.retry.abandoned", metadata.Counter, metadata.Count,
+		"Number of data points discarded after exceeding MaxRetries or MaxRetryAge.")
+	Set("collect.retry.abandoned", o.tags, r.get(func(r *retryState) interface{} { return r.abandoned }))
+	metadata.AddMetricMeta(metricRoot+"collect.retry.failures", metadata.Gauge, metadata.Count,
+		"Number of consecutive failed sends.")
+	Set("collect.retry.failures", o.tags, r.get(func(r *retryState) interface{} { return r.failures }))
+	metadata.AddMetricMeta(metricRoot+"collect.retry.backoff", metadata.Gauge, metadata.Second,
+		"Current delay between two attempts to send a failed batch.")
+	Set("collect.retry.backoff", o.tags, r.get(func(r *retryState) interface{} { return r.backoff.Seconds() }))
+	metadata.AddMetricMeta(metricRoot+"collect.retry.breaker", metadata.Gauge, metadata.None,
+		"State of the send circuit breaker. 0=closed, 1=open, 2=half-open.")
+	Set("collect.retry.breaker", o.tags, r.get(func(r *retryState) interface{} { return r.breaker }))
+}
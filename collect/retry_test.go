@@ -0,0 +1,65 @@
+package collect
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryFailure(t *testing.T) {
+	defer func(min, max time.Duration, n int) {
+		RetryMinBackoff, RetryMaxBackoff, MaxRetries = min, max, n
+	}(RetryMinBackoff, RetryMaxBackoff, MaxRetries)
+	RetryMinBackoff, RetryMaxBackoff, MaxRetries = time.Second, time.Second*4, 3
+	var r retryState
+	var b batchRetry
+	for i, expect := range []time.Duration{1, 2, 4, 4} {
+		wait, discard := r.failure(&b, 10)
+		if expect *= time.Second; wait < expect/2 || wait > expect {
+			t.Errorf("%d: wait %s not in [%s, %s]", i, wait, expect/2, expect)
+		}
+		if discard != (i == 3) {
+			t.Errorf("%d: unexpected discard %v", i, discard)
+		}
+	}
+	if r.abandoned != 10 || r.retries != 3 {
+		t.Errorf("expected 3 retries and 10 abandoned, got %d and %d", r.retries, r.abandoned)
+	}
+	r.success()
+	if r.backoff != 0 || r.breaker != breakerClosed {
+		t.Errorf("expected reset state, got backoff %s, breaker %d", r.backoff, r.breaker)
+	}
+	// Batches failing on concurrent senders are retried independently.
+	for i := 0; i < 4; i++ {
+		if _, discard := r.failure(&batchRetry{}, 10); discard {
+			t.Errorf("%d: batch discarded on its first failure", i)
+		}
+	}
+}
+
+func TestRetryBreaker(t *testing.T) {
+	defer func(min time.Duration, n int) {
+		RetryMinBackoff, BreakerThreshold = min, n
+	}(RetryMinBackoff, BreakerThreshold)
+	RetryMinBackoff, BreakerThreshold = time.Millisecond*10, 2
+	var r retryState
+	for i := 0; i < 2; i++ {
+		if wait := r.attempt(); wait != 0 {
+			t.Fatalf("%d: expected closed breaker to let the batch through, got wait %s", i, wait)
+		}
+		r.failure(&batchRetry{}, 1)
+	}
+	if wait := r.attempt(); wait <= 0 {
+		t.Fatal("expected open breaker to hold back the batch")
+	}
+	time.Sleep(r.backoff)
+	if wait := r.attempt(); wait != 0 {
+		t.Fatalf("expected a trial batch after the cooldown, got wait %s", wait)
+	}
+	if wait := r.attempt(); wait <= 0 {
+		t.Fatal("expected half-open breaker to hold back other batches")
+	}
+	r.success()
+	if wait := r.attempt(); wait != 0 {
+		t.Fatalf("expected closed breaker after a successful trial, got wait %s", wait)
+	}
+}
@@ -0,0 +1,108 @@
+package collect
+
+import (
+	"runtime"
+	"time"
+
+	"mosun_collector/metadata"
+)
+
+const (
+	descCollectAlloc          = "Bytes of allocated heap objects of the collector process."
+	descCollectGoRoutines     = "Number of goroutines of the collector process."
+	descCollectQueued         = "Number of data points in the output queue, waiting to be sent."
+	descCollectSent           = "Number of data points sent by the output."
+	descCollectDropped        = "Number of data points dropped by the output because its queue and spool were full or too old."
+	descCollectSpoolBytes     = "Size in bytes of the on-disk spool of the output."
+	descCollectPostTotalBytes = "Number of bytes sent by the output, after encoding and compression."
+	descCollectPostCount      = "Number of batches sent by the output."
+	descCollectPostError      = "Number of batches the output failed to send."
+	descCollectPostBatchSize  = "Number of data points per batch sent by the output."
+	descCollectPostDuration   = "Duration in milliseconds of each send by the output."
+	descCollectMetadataSent   = "Number of successful metadata sends."
+	descCollectMetadataError  = "Number of failed metadata sends."
+)
+
+// initSelfMetrics registers the collector self metrics: process health and,
+// for every output, the state of its queue, spool and retries.
+func initSelfMetrics() {
+	metadata.AddMetricMeta(metricRoot+"collect.alloc", metadata.Gauge, metadata.Bytes, descCollectAlloc)
+	Set("collect.alloc", Tags, func() interface{} {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	})
+	metadata.AddMetricMeta(metricRoot+"collect.goroutines", metadata.Gauge, metadata.Count, descCollectGoRoutines)
+	Set("collect.goroutines", Tags, func() interface{} {
+		return runtime.NumGoroutine()
+	})
+	metadata.AddMetricMeta(metricRoot+"collect.metadata.sent", metadata.Counter, metadata.Count, descCollectMetadataSent)
+	Set("collect.metadata.sent", Tags, func() interface{} {
+		ok, _ := metadata.SendStats()
+		return ok
+	})
+	metadata.AddMetricMeta(metricRoot+"collect.metadata.error", metadata.Counter, metadata.Count, descCollectMetadataError)
+	Set("collect.metadata.error", Tags, func() interface{} {
+		_, bad := metadata.SendStats()
+		return bad
+	})
+	metadata.AddMetricMeta(metricRoot+"collect.queued", metadata.Gauge, metadata.Item, descCollectQueued)
+	metadata.AddMetricMeta(metricRoot+"collect.sent", metadata.Counter, metadata.Count, descCollectSent)
+	metadata.AddMetricMeta(metricRoot+"collect.dropped", metadata.Counter, metadata.Count, descCollectDropped)
+	metadata.AddMetricMeta(metricRoot+"collect.spool.bytes", metadata.Gauge, metadata.Bytes, descCollectSpoolBytes)
+	metadata.AddMetricMeta(metricRoot+"collect.post.total_bytes", metadata.Counter, metadata.Bytes, descCollectPostTotalBytes)
+	metadata.AddMetricMeta(metricRoot+"collect.post.count", metadata.Counter, metadata.Count, descCollectPostCount)
+	metadata.AddMetricMeta(metricRoot+"collect.post.error", metadata.Counter, metadata.Count, descCollectPostError)
+	AggregateMeta(metricRoot+"collect.post.batchsize", metadata.Count, descCollectPostBatchSize)
+	AggregateMeta(metricRoot+"collect.post.duration", metadata.MilliSecond, descCollectPostDuration)
+	if Dedup {
+		metadata.AddMetricMeta(metricRoot+"collect.dedup.suppressed", metadata.Counter, metadata.Count, descCollectDedupSuppressed)
+		Set("collect.dedup.suppressed", Tags, changeOnly.suppressedCount)
+	}
+	if MaxSeries > 0 || len(SeriesLimits) > 0 {
+		metadata.AddMetricMeta(metricRoot+"collect.cardinality.rejected", metadata.Gauge, metadata.Count, descCollectCardinalityRejected)
+		metadata.AddMetricMeta(metricRoot+"collect.cardinality.dropped", metadata.Counter, metadata.Count, descCollectCardinalityDropped)
+		go func() {
+			for range time.Tick(Freq) {
+				seriesLimiter.report()
+			}
+		}()
+	}
+	for _, o := range outputs {
+		o.initMetrics()
+	}
+}
+
+func (o *output) initMetrics() {
+	Set("collect.queued", o.tags, func() interface{} {
+		o.Lock()
+		defer o.Unlock()
+		return len(o.queue)
+	})
+	Set("collect.sent", o.tags, func() interface{} {
+		o.slock.Lock()
+		defer o.slock.Unlock()
+		return o.sent
+	})
+	Set("collect.dropped", o.tags, func() interface{} {
+		o.slock.Lock()
+		defer o.slock.Unlock()
+		return o.dropped
+	})
+	if o.spool != nil {
+		Set("collect.spool.bytes", o.tags, func() interface{} {
+			o.spool.Lock()
+			defer o.spool.Unlock()
+			return o.spool.size
+		})
+	}
+	if bc, ok := o.sink.(byteCounter); ok {
+		Set("collect.post.total_bytes", o.tags, func() interface{} {
+			return bc.bytesSent()
+		})
+	}
+	if sm, ok := o.sink.(sinkMetrics); ok {
+		sm.initMetrics(o.tags)
+	}
+	initRetryMetrics(o)
+}
@@ -0,0 +1,259 @@
+package collect
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/metadata"
+	"mosun_collector/opentsdb"
+)
+
+// Sink is a destination for data points. Every sink added with AddSink gets
+// its own queue, spool and retry state, so a failing sink does not hold back
+// the others.
+type Sink interface {
+	// Name identifies the sink in logs, self metrics and the spool directory.
+	Name() string
+	// Send sends batch. A non-nil error means the whole batch is retried,
+	// unless it is a *rejectedError.
+	Send(batch []*opentsdb.DataPoint) error
+}
+
+// rejectedError is returned by Send when n data points of the batch were
+// rejected and would be rejected again: they are dropped instead of retried.
+type rejectedError struct {
+	n   int
+	msg string
+}
+
+func (e *rejectedError) Error() string { return e.msg }
+
+// retriedStatus are the client errors that don't depend on the data but on
+// the configuration, the batch size or the load of the server: the batch is
+// retried after them.
+var retriedStatus = map[int]bool{
+	http.StatusUnauthorized:          true,
+	http.StatusForbidden:             true,
+	http.StatusNotFound:              true,
+	http.StatusRequestEntityTooLarge: true,
+	http.StatusTooManyRequests:       true,
+}
+
+// byteCounter is implemented by sinks counting the bytes they send.
+type byteCounter interface {
+	bytesSent() int64
+}
+
+// sinkMetrics is implemented by sinks with their own self metrics, tagged
+// with tags.
+type sinkMetrics interface {
+	initMetrics(tags opentsdb.TagSet)
+}
+
+// sentBytes implements byteCounter.
+type sentBytes struct {
+	n int64
+}
+
+func (b *sentBytes) add(n int) {
+	atomic.AddInt64(&b.n, int64(n))
+}
+
+func (b *sentBytes) bytesSent() int64 {
+	return atomic.LoadInt64(&b.n)
+}
+
+// postBatch posts body, the encoding of a batch of n data points, to url
+// with header and counts the bytes sent in sent. Client errors other than
+// retriedStatus mean the data was rejected and will be rejected again: a
+// *rejectedError is returned.
+func postBatch(name, url string, header http.Header, body []byte, n int, sent *sentBytes) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	sent.add(len(body))
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 == 4 && !retriedStatus[resp.StatusCode] {
+		return &rejectedError{n: n, msg: fmt.Sprintf("%d data points rejected: %s %s", n, resp.Status, b)}
+	}
+	return fmt.Errorf("%s: %s %s", url, resp.Status, b)
+}
+
+type tsdbSink struct {
+	name       string
+	url        string
+	deadLetter string
+	sentBytes
+
+	sync.Mutex // protects writes to deadLetter
+}
+
+// NewOpenTSDBSink returns a sink posting to the /api/put route of the
+// OpenTSDB or Bosun host. Data points rejected by OpenTSDB are logged and,
+// if deadLetter is not empty, appended to that file instead of being
+// retried.
+func NewOpenTSDBSink(name string, host *url.URL, deadLetter string) (Sink, error) {
+	u, err := host.Parse("/api/put?details")
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(u.Host, ":") {
+		u.Host = "localhost" + u.Host
+	}
+	return &tsdbSink{name: name, url: u.String(), deadLetter: deadLetter}, nil
+}
+
+func (s *tsdbSink) Name() string { return s.name }
+
+// putDetails is the /api/put?details response body.
+type putDetails struct {
+	Success int
+	Failed  int
+	Errors  []putError
+}
+
+type putError struct {
+	Datapoint json.RawMessage `json:"datapoint"`
+	Error     string          `json:"error"`
+}
+
+func (s *tsdbSink) Send(batch []*opentsdb.DataPoint) error {
+	resp, err := SendDataPoints(batch, s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.Request != nil {
+		s.add(int(resp.Request.ContentLength))
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error(err)
+	}
+	// OpenTSDB stores the valid data points of a batch and reports the
+	// others. Those would fail again, so only they are discarded and the
+	// batch is not retried.
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusBadRequest {
+		var d putDetails
+		if err := json.Unmarshal(body, &d); err == nil && (d.Success > 0 || d.Failed > 0) {
+			if d.Failed > 0 {
+				s.reject(d)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %s %s", s.url, resp.Status, body)
+}
+
+// reject logs the data points OpenTSDB failed to store and writes them to
+// the dead letter file.
+func (s *tsdbSink) reject(d putDetails) {
+	log.Errorf("%s: %d data points rejected, %d stored", s.name, d.Failed, d.Success)
+	if !DisableDefaultCollectors {
+		metadata.AddMetricMeta(metricRoot+"collect.put.failed", metadata.Counter, metadata.Count,
+			"Number of data points rejected by OpenTSDB.")
+		Add("collect.put.failed", Tags.Copy().Merge(opentsdb.TagSet{"output": s.name}), int64(d.Failed))
+	}
+	for _, e := range d.Errors {
+		log.Errorf("%s: %s: %s", s.name, e.Error, e.Datapoint)
+	}
+	if s.deadLetter == "" || len(d.Errors) == 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	f, err := os.OpenFile(s.deadLetter, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, e := range d.Errors {
+		if err := enc.Encode(e); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+}
+
+type fileSink struct {
+	name string
+	sentBytes
+	sync.Mutex
+	f *os.File
+}
+
+// NewFileSink returns a sink appending data points to the file at path, one
+// JSON object per line.
+func NewFileSink(name, path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{name: name, f: f}, nil
+}
+
+func (s *fileSink) Name() string { return s.name }
+
+func (s *fileSink) Send(batch []*opentsdb.DataPoint) error {
+	s.Lock()
+	defer s.Unlock()
+	w := bufio.NewWriter(s.f)
+	n := 0
+	for _, d := range batch {
+		j, err := d.MarshalJSON()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		w.Write(j)
+		w.WriteByte('\n')
+		n += len(j) + 1
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	s.add(n)
+	return nil
+}
+
+type printSink struct{}
+
+func (printSink) Name() string { return "print" }
+
+func (printSink) Send(batch []*opentsdb.DataPoint) error {
+	for _, d := range batch {
+		j, err := d.MarshalJSON()
+		if err != nil {
+			log.Error(err)
+		}
+		log.Info(string(j))
+	}
+	return nil
+}
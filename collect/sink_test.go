@@ -0,0 +1,118 @@
+package collect
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestOpenTSDBSinkDetails(t *testing.T) {
+	defer func(b bool) { DisableDefaultCollectors = b }(DisableDefaultCollectors)
+	DisableDefaultCollectors = true
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	deadLetter := filepath.Join(dir, "rejected.json")
+	var status int
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	s, err := NewOpenTSDBSink("test", u, deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := []*opentsdb.DataPoint{testPoint("test.a", 1, 1), testPoint("test.b", 1, 1)}
+	tests := []struct {
+		status   int
+		body     string
+		retry    bool
+		rejected int // total lines in the dead letter file
+	}{
+		{http.StatusNoContent, "", false, 0},
+		// Partial failure: only the rejected data point is discarded.
+		{http.StatusBadRequest, `{"success":1,"failed":1,"errors":[{"datapoint":{"metric":"test.b"},"error":"bad"}]}`, false, 1},
+		// Total failure: retrying would fail again.
+		{http.StatusBadRequest, `{"success":0,"failed":2,"errors":[{"datapoint":{"metric":"test.a"},"error":"bad"},{"datapoint":{"metric":"test.b"},"error":"bad"}]}`, false, 3},
+		// An error that is not a details response is retried.
+		{http.StatusBadRequest, `<html>Bad Request</html>`, true, 3},
+		{http.StatusInternalServerError, `{"error":{"code":500}}`, true, 3},
+	}
+	for i, test := range tests {
+		status, body = test.status, test.body
+		err := s.Send(batch)
+		if (err != nil) != test.retry {
+			t.Errorf("%d: expected retry %v, got error %v", i, test.retry, err)
+		}
+		b, _ := ioutil.ReadFile(deadLetter)
+		if n := strings.Count(string(b), "\n"); n != test.rejected {
+			t.Errorf("%d: expected %d dead letters, got %d", i, test.rejected, n)
+		}
+	}
+}
+
+func TestPostBatch(t *testing.T) {
+	defer func(b bool) { DisableDefaultCollectors = b }(DisableDefaultCollectors)
+	DisableDefaultCollectors = true
+	var status int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	var sent sentBytes
+	for _, test := range []struct {
+		status   int
+		rejected bool
+		retry    bool
+	}{
+		{http.StatusNoContent, false, false},
+		{http.StatusBadRequest, true, false},
+		{http.StatusUnauthorized, false, true},
+		{http.StatusForbidden, false, true},
+		{http.StatusNotFound, false, true},
+		{http.StatusRequestEntityTooLarge, false, true},
+		{http.StatusTooManyRequests, false, true},
+		{http.StatusInternalServerError, false, true},
+	} {
+		status = test.status
+		err := postBatch("test", ts.URL, nil, []byte("x"), 2, &sent)
+		r, rejected := err.(*rejectedError)
+		if rejected != test.rejected || (err != nil && !rejected) != test.retry {
+			t.Errorf("%d: expected rejected %v and retry %v, got %v", test.status, test.rejected, test.retry, err)
+		}
+		if rejected && r.n != 2 {
+			t.Errorf("%d: expected 2 rejected data points, got %d", test.status, r.n)
+		}
+	}
+	// Rejected data points are dropped, not sent.
+	o := &output{sink: &rejectSink{n: 2}}
+	if !o.sendBatch([]*opentsdb.DataPoint{testPoint("test.a", 1, 1), testPoint("test.b", 1, 1), testPoint("test.c", 1, 1)}) {
+		t.Fatal("expected a rejected batch not to be retried")
+	}
+	if o.sent != 1 || o.dropped != 2 {
+		t.Errorf("expected 1 sent and 2 dropped, got %d and %d", o.sent, o.dropped)
+	}
+}
+
+// rejectSink rejects n data points of every batch.
+type rejectSink struct {
+	n int
+}
+
+func (s *rejectSink) Name() string { return "reject" }
+
+func (s *rejectSink) Send(batch []*opentsdb.DataPoint) error {
+	return &rejectedError{n: s.n, msg: "rejected"}
+}
@@ -0,0 +1,167 @@
+package collect
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// sketchAccuracy is the maximum relative error of the quantiles returned
+	// by a sketch.
+	sketchAccuracy = 0.01
+
+	// sketchMaxBuckets bounds the number of buckets per sign. Above it the
+	// buckets of the smallest magnitudes are merged, so only the accuracy of
+	// the lowest quantiles degrades.
+	sketchMaxBuckets = 2048
+)
+
+var (
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// sketch is a DDSketch: a mergeable summary of a stream of values counting
+// them in logarithmically sized buckets. Its size depends on the range of
+// the values, not on their number, and any quantile is estimated within a
+// relative error of sketchAccuracy. The count, sum, min and max are exact.
+type sketch struct {
+	pos, neg map[int]uint64 // bucket index of |v| to count
+	zero     uint64
+	count    uint64
+	sum      float64
+	min, max float64
+}
+
+func newSketch() *sketch {
+	return &sketch{
+		pos: make(map[int]uint64),
+		neg: make(map[int]uint64),
+	}
+}
+
+func sketchIndex(v float64) int {
+	return int(math.Ceil(math.Log(v) / sketchLogGamma))
+}
+
+// sketchValue returns the value representing the bucket at index i.
+func sketchValue(i int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1)
+}
+
+func (s *sketch) add(v float64) {
+	s.addN(v, 1)
+}
+
+// addN adds v n times.
+func (s *sketch) addN(v float64, n uint64) {
+	if math.IsNaN(v) || n == 0 {
+		return
+	}
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count += n
+	s.sum += v * float64(n)
+	switch {
+	case v > 0:
+		s.pos[sketchIndex(v)] += n
+		collapse(s.pos)
+	case v < 0:
+		s.neg[sketchIndex(-v)] += n
+		collapse(s.neg)
+	default:
+		s.zero += n
+	}
+}
+
+// merge adds the values summarized by o to s.
+func (s *sketch) merge(o *sketch) {
+	if o.count == 0 {
+		return
+	}
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.sum += o.sum
+	s.zero += o.zero
+	for i, n := range o.pos {
+		s.pos[i] += n
+	}
+	for i, n := range o.neg {
+		s.neg[i] += n
+	}
+	collapse(s.pos)
+	collapse(s.neg)
+}
+
+// collapse merges the lowest buckets of b until it has at most
+// sketchMaxBuckets buckets.
+func collapse(b map[int]uint64) {
+	if len(b) <= sketchMaxBuckets {
+		return
+	}
+	idx := sortedIndexes(b)
+	n := len(idx) - sketchMaxBuckets
+	to := idx[n]
+	for _, i := range idx[:n] {
+		b[to] += b[i]
+		delete(b, i)
+	}
+}
+
+func sortedIndexes(b map[int]uint64) []int {
+	idx := make([]int, 0, len(b))
+	for i := range b {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+// quantile returns the estimated value at quantile q, between 0 and 1. Like
+// the nearest-rank method, it returns the smallest value of rank at least
+// q*(count-1). 0 and 1 return the exact min and max.
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+	rank := uint64(math.Ceil(q * float64(s.count-1)))
+	var n uint64
+	v := s.max
+	found := false
+	// Negative values first, largest magnitude first.
+	idx := sortedIndexes(s.neg)
+	for j := len(idx) - 1; j >= 0 && !found; j-- {
+		if n += s.neg[idx[j]]; n > rank {
+			v, found = -sketchValue(idx[j]), true
+		}
+	}
+	if !found {
+		if n += s.zero; n > rank {
+			v, found = 0, true
+		}
+	}
+	if !found {
+		for _, i := range sortedIndexes(s.pos) {
+			if n += s.pos[i]; n > rank {
+				v = sketchValue(i)
+				break
+			}
+		}
+	}
+	return math.Max(s.min, math.Min(s.max, v))
+}
@@ -0,0 +1,56 @@
+package collect
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSketchQuantile(t *testing.T) {
+	a, b := newSketch(), newSketch()
+	var values []float64
+	for i := 0; i < 10000; i++ {
+		v := rand.ExpFloat64()*100 - 20
+		values = append(values, v)
+		if i%2 == 0 {
+			a.add(v)
+		} else {
+			b.add(v)
+		}
+	}
+	a.merge(b)
+	sort.Float64s(values)
+	if a.count != uint64(len(values)) {
+		t.Fatalf("expected count %d, got %d", len(values), a.count)
+	}
+	for _, q := range []float64{0, .01, .25, .5, .95, .99, 1} {
+		expected := values[int(math.Ceil(q*float64(len(values)-1)))]
+		got := a.quantile(q)
+		if math.Abs(got-expected) > math.Abs(expected)*sketchAccuracy+1e-9 {
+			t.Errorf("quantile %v: expected %v, got %v", q, expected, got)
+		}
+	}
+}
+
+func TestSketchAddN(t *testing.T) {
+	s := newSketch()
+	s.addN(10, 3)
+	s.add(40)
+	if s.count != 4 || s.sum != 70 {
+		t.Errorf("expected count 4 and sum 70, got %d and %v", s.count, s.sum)
+	}
+	if q := s.quantile(.5); math.Abs(q-10) > 10*sketchAccuracy {
+		t.Errorf("expected a median of 10, got %v", q)
+	}
+}
+
+func TestPercentileName(t *testing.T) {
+	for p, expected := range map[float64]string{
+		.5: "median", .95: "95", .99: "99", .999: "999", .75: "75", .29: "29", .57: "57", .07: "7", .9999: "9999",
+	} {
+		if got := percentileName(p); got != expected {
+			t.Errorf("percentileName(%v): expected %s, got %s", p, expected, got)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package collect
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/opentsdb"
+)
+
+const (
+	spoolExt = ".spool"
+
+	// spoolSegmentSize is the size above which the active segment is closed
+	// and a new one is started.
+	spoolSegmentSize = 4 * 1024 * 1024
+)
+
+// spool is an on-disk write-ahead queue of data points. Data is appended to
+// the active segment file and read back one closed segment at a time, oldest
+// first. Each segment holds one JSON encoded data point per line. A segment
+// that was read stays on disk until ack is called once its data was sent, so
+// it is read again after a crash.
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	dropped  func(n int) // called with the number of discarded data points
+
+	sync.Mutex
+	segs    []string         // closed segments, oldest first
+	reading map[string]int64 // size of the segments read but not acked
+	size    int64            // bytes on disk, including the active segment
+	last    int64            // name of the last created segment
+	f       *os.File         // active segment
+	w       *bufio.Writer
+	fsize   int64
+}
+
+// openSpool opens or creates the spool in dir. Segments left over from a
+// previous run are kept and will be read before any new data.
+func openSpool(dir string, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		reading:  make(map[string]int64),
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		if n > s.last {
+			s.last = n
+		}
+		s.segs = append(s.segs, name)
+		s.size += fi.Size()
+	}
+	sort.Strings(s.segs)
+	if len(s.segs) > 0 {
+		log.Infof("spool: found %d segments (%d bytes) in %s", len(s.segs), s.size, dir)
+	}
+	return s, nil
+}
+
+// pending returns true if the spool holds data that has not been read yet.
+func (s *spool) pending() bool {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.segs) > 0 || s.fsize > 0
+}
+
+// write appends dps to the active segment. It returns the number of data
+// points that could not be written.
+func (s *spool) write(dps []*opentsdb.DataPoint) (failed int) {
+	s.Lock()
+	defer s.Unlock()
+	if s.f == nil {
+		if err := s.create(); err != nil {
+			log.Error(err)
+			return len(dps)
+		}
+	}
+	for i, dp := range dps {
+		b, err := dp.MarshalJSON()
+		if err != nil {
+			log.Error(err)
+			failed++
+			continue
+		}
+		b = append(b, '\n')
+		if _, err := s.w.Write(b); err != nil {
+			log.Error(err)
+			return failed + len(dps) - i
+		}
+		s.fsize += int64(len(b))
+		s.size += int64(len(b))
+	}
+	if err := s.w.Flush(); err != nil {
+		log.Error(err)
+	}
+	if s.fsize > spoolSegmentSize {
+		s.rotate()
+	}
+	s.trim()
+	return failed
+}
+
+// read removes the oldest segment from the spool and returns its name and
+// data points. The active segment is closed if there is no other segment to
+// read. The segment file is kept until it is acked.
+func (s *spool) read() (string, []*opentsdb.DataPoint, error) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.segs) == 0 && s.fsize > 0 {
+		s.rotate()
+	}
+	for len(s.segs) > 0 {
+		name := s.segs[0]
+		s.segs = s.segs[1:]
+		path := filepath.Join(s.dir, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if s.maxAge > 0 && time.Since(fi.ModTime()) > s.maxAge {
+			s.size -= fi.Size()
+			s.remove(path, "older than "+s.maxAge.String())
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			s.size -= fi.Size()
+			return "", nil, err
+		}
+		var dps []*opentsdb.DataPoint
+		for _, line := range bytes.Split(b, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var dp opentsdb.DataPoint
+			if err := json.Unmarshal(line, &dp); err != nil {
+				log.Errorf("spool: %s: %v", name, err)
+				continue
+			}
+			dps = append(dps, &dp)
+		}
+		if len(dps) == 0 {
+			s.size -= fi.Size()
+			if err := os.Remove(path); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		s.reading[name] = fi.Size()
+		log.Debugf("spool: read %d data points from %s", len(dps), name)
+		return name, dps, nil
+	}
+	return "", nil, nil
+}
+
+// ack removes the segment name, returned by read, once its data has been
+// sent or spooled again.
+func (s *spool) ack(name string) {
+	s.Lock()
+	defer s.Unlock()
+	size, ok := s.reading[name]
+	if !ok {
+		return
+	}
+	delete(s.reading, name)
+	s.size -= size
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+		log.Error(err)
+	}
+}
+
+// close flushes and closes the active segment.
+func (s *spool) close() {
+	s.Lock()
+	defer s.Unlock()
+	if s.f != nil {
+		s.rotate()
+	}
+}
+
+func (s *spool) create() error {
+	n := time.Now().UnixNano()
+	if n <= s.last {
+		n = s.last + 1
+	}
+	s.last = n
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("%019d%s", n, spoolExt)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	s.fsize = 0
+	return nil
+}
+
+// rotate closes the active segment and makes it available for reading.
+func (s *spool) rotate() {
+	if err := s.w.Flush(); err != nil {
+		log.Error(err)
+	}
+	if err := s.f.Close(); err != nil {
+		log.Error(err)
+	}
+	name := filepath.Base(s.f.Name())
+	if s.fsize > 0 {
+		s.segs = append(s.segs, name)
+	} else if err := os.Remove(s.f.Name()); err != nil {
+		log.Error(err)
+	}
+	s.f = nil
+	s.w = nil
+	s.fsize = 0
+}
+
+// trim removes the oldest closed segments until the spool fits in maxBytes.
+// The segments being read are not counted: their data is in the queue and
+// they are removed once it has been sent.
+func (s *spool) trim() {
+	var reading int64
+	for _, n := range s.reading {
+		reading += n
+	}
+	for s.maxBytes > 0 && s.size-reading > s.maxBytes && len(s.segs) > 0 {
+		path := filepath.Join(s.dir, s.segs[0])
+		s.segs = s.segs[1:]
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		s.size -= fi.Size()
+		s.remove(path, "spool larger than "+strconv.FormatInt(s.maxBytes, 10)+" bytes")
+	}
+}
+
+// remove deletes the segment at path and counts its data points as dropped.
+func (s *spool) remove(path, reason string) {
+	n := 0
+	if b, err := ioutil.ReadFile(path); err == nil {
+		n = bytes.Count(b, []byte("\n"))
+	}
+	if err := os.Remove(path); err != nil {
+		log.Error(err)
+	}
+	log.Errorf("spool: discarded %d data points from %s: %s", n, filepath.Base(path), reason)
+	if s.dropped != nil {
+		s.dropped(n)
+	}
+}
@@ -0,0 +1,174 @@
+package collect
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"mosun_collector/opentsdb"
+)
+
+func TestSpoolOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.write([]*opentsdb.DataPoint{testPoint("test.spool", 1, 1), testPoint("test.spool", 1, 2)})
+	s.close()
+	s.write([]*opentsdb.DataPoint{testPoint("test.spool", 1, 3)})
+	s.close()
+	// Reopen to make sure segments survive a restart.
+	s, err = openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.pending() {
+		t.Fatal("expected pending data")
+	}
+	var got []float64
+	for s.pending() {
+		_, dps, err := s.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range dps {
+			got = append(got, d.Value.(float64))
+		}
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("expected [1 2 3], got %v", got)
+	}
+}
+
+func TestSpoolAck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.write([]*opentsdb.DataPoint{testPoint("test.spool", 1, 1), testPoint("test.spool", 1, 2)})
+	o := &output{sink: printSink{}, batchSize: 1, spool: s}
+	o.unspool()
+	if len(o.queue) != 2 {
+		t.Fatalf("expected 2 unspooled data points, got %d", len(o.queue))
+	}
+	o.ack(o.queue[:1])
+	// The segment must survive a crash until all of its data was sent.
+	if s, err = openSpool(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	} else if !s.pending() {
+		t.Fatal("expected segment to be kept before it is acked")
+	}
+	o.ack(o.queue[1:])
+	if s, err = openSpool(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	} else if s.pending() {
+		t.Fatal("expected segment to be removed once acked")
+	}
+}
+
+func TestStopSpools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &output{sink: printSink{}, spool: s}
+	o.inflight = map[int][]*opentsdb.DataPoint{0: {testPoint("test.spool", 1, 1)}}
+	o.queue = []*opentsdb.DataPoint{testPoint("test.spool", 1, 2)}
+	if lost := o.stop(); lost != 0 {
+		t.Fatalf("expected no lost data points, got %d", lost)
+	}
+	o.enqueue([]*opentsdb.DataPoint{testPoint("test.spool", 1, 3)})
+	if len(o.queue) != 0 {
+		t.Fatalf("expected empty queue after stop, got %d", len(o.queue))
+	}
+	s.close()
+	var got []float64
+	for s.pending() {
+		_, dps, err := s.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range dps {
+			got = append(got, d.Value.(float64))
+		}
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("expected [1 2 3], got %v", got)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	defer func(n int) { BatchBytes = n }(BatchBytes)
+	o := &output{batchSize: 3}
+	for i := 0; i < 4; i++ {
+		o.queue = append(o.queue, testPoint("test.batch", 1, i))
+	}
+	if b := o.batch(false); len(b) != 3 {
+		t.Fatalf("expected a full batch of 3, got %d", len(b))
+	}
+	if b := o.batch(false); b != nil {
+		t.Fatalf("expected no partial batch without force, got %d", len(b))
+	}
+	if b := o.batch(true); len(b) != 1 {
+		t.Fatalf("expected a forced batch of 1, got %d", len(b))
+	}
+	BatchBytes = encodedSize(testPoint("test.batch", 1, 0)) * 2
+	for i := 0; i < 3; i++ {
+		o.queue = append(o.queue, testPoint("test.batch", 1, i))
+	}
+	if b := o.batch(false); len(b) != 2 {
+		t.Fatalf("expected a batch of 2 limited by size, got %d", len(b))
+	}
+}
+
+func TestSpoolTrimReading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.write([]*opentsdb.DataPoint{testPoint("test.spool", 1, 1)})
+	s.close()
+	if _, dps, err := s.read(); err != nil || len(dps) != 1 {
+		t.Fatalf("expected 1 data point, got %d, %v", len(dps), err)
+	}
+	// The segment being read does not count towards the limit.
+	s.maxBytes = s.size * 2
+	s.write([]*opentsdb.DataPoint{testPoint("test.spool", 1, 2)})
+	s.close()
+	s.write([]*opentsdb.DataPoint{testPoint("test.spool", 1, 3)})
+	if len(s.segs) != 1 {
+		t.Errorf("expected the closed segment to be kept, got %d segments", len(s.segs))
+	}
+}
+
+func TestCleanAll(t *testing.T) {
+	dps := cleanAll([]*opentsdb.DataPoint{testPoint("test.clean", 1, "12"), testPoint("test.clean", 1, "x")})
+	if len(dps) != 1 || dps[0].Value != int64(12) {
+		t.Fatalf("expected one data point with value 12, got %v", dps)
+	}
+	// MarshalJSON must not modify a clean data point shared by the outputs.
+	d := *dps[0]
+	if _, err := dps[0].MarshalJSON(); err != nil || dps[0].Value != d.Value || dps[0].Metric != d.Metric {
+		t.Errorf("clean data point modified by MarshalJSON: %v", err)
+	}
+}
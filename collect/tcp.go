@@ -0,0 +1,72 @@
+package collect
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// tcpConn is a persistent TCP connection that is dialed on first use and
+// again after any write error or once the server closed it.
+type tcpConn struct {
+	addr string
+	// lines, if not nil, is called with every line the server writes back.
+	// The responses are drained either way so the server never blocks.
+	lines func(line string)
+
+	sync.Mutex
+	conn net.Conn
+}
+
+// write writes b to the connection, dialing it first if needed. The
+// connection is closed on error so the next write reconnects.
+func (c *tcpConn) write(b []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, time.Second*10)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+		go c.read(conn)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(time.Minute))
+	if _, err := c.conn.Write(b); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+// read reads the responses of the server on conn until it is closed, and
+// then closes conn so that the next write reconnects.
+func (c *tcpConn) read(conn net.Conn) {
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		if c.lines != nil {
+			c.lines(s.Text())
+		}
+	}
+	if err := s.Err(); err != nil {
+		log.Debugf("%s: %v", c.addr, err)
+	}
+	c.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.Unlock()
+	conn.Close()
+}
+
+// tcpAddr returns addr with port appended if it has none.
+func tcpAddr(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, port)
+	}
+	return addr
+}
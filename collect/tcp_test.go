@@ -0,0 +1,57 @@
+package collect
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPConnResponses(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// Answer every command with an error, then hang up.
+			s := bufio.NewScanner(conn)
+			if s.Scan() {
+				conn.Write([]byte("put: illegal argument\n"))
+			}
+			conn.Close()
+		}
+	}()
+	lines := make(chan string, 2)
+	c := &tcpConn{addr: l.Addr().String(), lines: func(line string) { lines <- line }}
+	for i := 0; i < 2; i++ {
+		if err := c.write([]byte("put test 1 1 host=h\n")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case line := <-lines:
+			if line != "put: illegal argument" {
+				t.Fatalf("unexpected response %q", line)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for the response")
+		}
+		// Wait for the reader to see the connection closed.
+		for deadline := time.Now().Add(time.Second * 5); ; time.Sleep(time.Millisecond * 10) {
+			c.Lock()
+			closed := c.conn == nil
+			c.Unlock()
+			if closed {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("connection not closed after EOF")
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package collect
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/opentsdb"
+)
+
+type telnetSink struct {
+	name string
+	conn tcpConn
+	sentBytes
+}
+
+// NewTelnetSink returns a sink streaming data points to addr (port 4242 if
+// unspecified) with the OpenTSDB telnet put protocol. OpenTSDB only answers
+// put commands on error and the errors can't be matched to a batch, so they
+// are logged and the rejected data points are not retried.
+func NewTelnetSink(name, addr string) Sink {
+	return &telnetSink{
+		name: name,
+		conn: tcpConn{
+			addr:  tcpAddr(addr, "4242"),
+			lines: func(line string) { log.Errorf("%s: %s", name, line) },
+		},
+	}
+}
+
+func (s *telnetSink) Name() string { return s.name }
+
+func (s *telnetSink) Send(batch []*opentsdb.DataPoint) error {
+	var buf bytes.Buffer
+	for _, dp := range batch {
+		if err := writePut(&buf, dp); err != nil {
+			log.Errorf("%s: %s: %v", s.name, dp.Metric, err)
+		}
+	}
+	if err := s.conn.write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.add(buf.Len())
+	return nil
+}
+
+// writePut writes dp as a telnet put command to buf. dp is shared with the
+// other outputs and must not be modified.
+func writePut(buf *bytes.Buffer, dp *opentsdb.DataPoint) error {
+	metric, err := opentsdb.Clean(dp.Metric)
+	if err != nil {
+		return err
+	}
+	var value string
+	switch v := dp.Value.(type) {
+	case int:
+		value = strconv.Itoa(v)
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case uint64:
+		value = strconv.FormatUint(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case *big.Int:
+		value = v.String()
+	default:
+		f, ok := floatValue(v)
+		if !ok {
+			return fmt.Errorf("bad value: %v", v)
+		}
+		value = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	keys := make([]string, 0, len(dp.Tags))
+	for k := range dp.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var tags bytes.Buffer
+	for _, k := range keys {
+		kc, err := opentsdb.Clean(k)
+		if err != nil {
+			return err
+		}
+		vc, err := opentsdb.Clean(dp.Tags[k])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&tags, " %s=%s", kc, vc)
+	}
+	fmt.Fprintf(buf, "put %s %d %s%s\n", metric, dp.Timestamp, value, tags.Bytes())
+	return nil
+}
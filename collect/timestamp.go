@@ -0,0 +1,38 @@
+package collect
+
+import (
+	"time"
+
+	"mosun_collector/opentsdb"
+)
+
+// Milliseconds makes the self metrics use millisecond timestamps and the
+// sinks without a native millisecond format default to one. Data points may
+// carry second or millisecond timestamps either way: like OpenTSDB, sinks
+// tell them apart by their magnitude.
+var Milliseconds bool
+
+// timestamp returns the current time in seconds, or milliseconds if
+// Milliseconds is set.
+func timestamp() int64 {
+	if Milliseconds {
+		return time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	return time.Now().Unix()
+}
+
+// timestampMillis returns ts, in seconds or milliseconds, in milliseconds.
+func timestampMillis(ts int64) int64 {
+	if ts > opentsdb.MaxSecondTimestamp {
+		return ts
+	}
+	return ts * 1000
+}
+
+// timestampSeconds returns ts, in seconds or milliseconds, in whole seconds.
+func timestampSeconds(ts int64) int64 {
+	if ts > opentsdb.MaxSecondTimestamp {
+		return ts / 1000
+	}
+	return ts
+}
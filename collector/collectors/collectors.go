@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -86,6 +87,11 @@ var (
 	// specified.
 	DefaultFreq = time.Second * 15
 
+	// Milliseconds stamps data points with the time they are collected at,
+	// in milliseconds, instead of the current second. Second timestamps
+	// given to AddTS or read from external collectors are converted.
+	Milliseconds bool
+
 	timestamp              = time.Now().Unix()
 	tlock                  sync.Mutex
 	AddTags                opentsdb.TagSet
@@ -93,6 +99,14 @@ var (
 	AddProcessDotNetConfig = func(params conf.ProcessDotNet) error {
 		return fmt.Errorf("process_dotnet watching not implemented on this platform")
 	}
+
+	// stop is closed by Stop to end the collection loops.
+	stop     = make(chan struct{})
+	stopOnce sync.Once
+
+	throttleLock sync.Mutex
+	slowdown     = 1
+	shed         []string
 )
 
 func init() {
@@ -106,6 +120,9 @@ func init() {
 }
 
 func now() (t int64) {
+	if Milliseconds {
+		return time.Now().UnixNano() / int64(time.Millisecond)
+	}
 	tlock.Lock()
 	t = timestamp
 	tlock.Unlock()
@@ -129,6 +146,38 @@ func Search(s []string) []Collector {
 	return r
 }
 
+// Stop makes interval and program collectors return after their current
+// run. Collectors that run forever, like listeners, are not stopped.
+func Stop() {
+	stopOnce.Do(func() { close(stop) })
+}
+
+// Throttle multiplies the interval of interval and program collectors by
+// factor and skips the runs of those whose name contains one of the names
+// in skip. Throttle(1, nil) restores normal collection.
+func Throttle(factor int, skip []string) {
+	if factor < 1 {
+		factor = 1
+	}
+	throttleLock.Lock()
+	slowdown = factor
+	shed = skip
+	throttleLock.Unlock()
+}
+
+// throttled returns the interval to use for the collector called name and
+// whether its next run must be skipped.
+func throttled(name string, interval time.Duration) (time.Duration, bool) {
+	throttleLock.Lock()
+	defer throttleLock.Unlock()
+	for _, s := range shed {
+		if strings.Contains(name, s) {
+			return interval * time.Duration(slowdown), true
+		}
+	}
+	return interval * time.Duration(slowdown), false
+}
+
 // Run runs specified collectors. Use nil for all collectors.
 func Run(cs []Collector) chan *opentsdb.DataPoint {
 	if cs == nil {
@@ -183,13 +232,40 @@ func AddTS(md *opentsdb.MultiDataPoint, name string, ts int64, value interface{}
 	tags = AddTags.Copy().Merge(tags)
 	d := opentsdb.DataPoint{
 		Metric:    name,
-		Timestamp: ts,
+		Timestamp: normalizeTimestamp(ts),
 		Value:     value,
 		Tags:      tags,
 	}
 	*md = append(*md, &d)
 }
 
+// normalizeTimestamp converts ts from seconds to milliseconds if
+// Milliseconds is set and ts is in seconds.
+func normalizeTimestamp(ts int64) int64 {
+	if Milliseconds && ts <= opentsdb.MaxSecondTimestamp {
+		return ts * 1000
+	}
+	return ts
+}
+
+// parseTimestamp parses a timestamp in seconds, possibly with a fraction, or
+// milliseconds. Fractions are kept in milliseconds if Milliseconds is set,
+// and dropped otherwise.
+func parseTimestamp(s string) (int64, error) {
+	if !strings.Contains(s, ".") {
+		ts, err := strconv.ParseInt(s, 10, 64)
+		return normalizeTimestamp(ts), err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if Milliseconds {
+		return int64(f*1000 + .5), nil
+	}
+	return int64(f), nil
+}
+
 // Add appends a new data point with given metric name, value, and tags. Tags
 // may be nil. If tags is nil or does not contain a host key, it will be
 // automatically added. If the value of the host key is the empty string, it
@@ -1,6 +1,11 @@
 package collectors
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"mosun_collector/opentsdb"
+)
 
 func TestIsDigit(t *testing.T) {
 	if IsDigit("1a3") {
@@ -10,3 +15,126 @@ func TestIsDigit(t *testing.T) {
 		t.Error("029: expected true")
 	}
 }
+
+func TestParseStatsd(t *testing.T) {
+	m, err := parseStatsd("app.req:3|c|@0.5|#env:prod,canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.name != "app.req" || m.typ != "c" || m.value != 3 || m.rate != 0.5 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+	if m.tags["env"] != "prod" || m.tags["canary"] != "true" {
+		t.Errorf("unexpected tags %v", m.tags)
+	}
+	if m, err := parseStatsd("app.conns:-2|g"); err != nil || !m.relative || m.value != -2 {
+		t.Errorf("expected relative gauge -2, got %+v, %v", m, err)
+	}
+	for _, line := range []string{"app.req", "app.req:x|c", "app.req:1|z", ":1|c"} {
+		if _, err := parseStatsd(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+}
+
+func TestStatsdCount(t *testing.T) {
+	s := &StatsdListener{counts: make(map[string]float64)}
+	var n int64
+	for i := 0; i < 3; i++ {
+		n += s.count("app.req", 1/0.3)
+	}
+	if n != 10 {
+		t.Errorf("expected 10 counts at rate 0.3, got %d", n)
+	}
+	if n := s.count("app.half", 0.5) + s.count("app.half", 0.5); n != 1 {
+		t.Errorf("expected 1 count from two halves, got %d", n)
+	}
+}
+
+func TestStatsdSet(t *testing.T) {
+	s := &StatsdListener{sets: map[string]map[string]bool{
+		"app.users": {"a": true, "b": true},
+	}}
+	size := s.setSize("app.users")
+	if n := size(); n != 2 {
+		t.Errorf("expected 2 members, got %v", n)
+	}
+	if n := size(); n != 0 {
+		t.Errorf("expected the set to be emptied by the flush, got %v members", n)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	defer func() { Milliseconds = false }()
+	tests := []struct {
+		in           string
+		milliseconds bool
+		expected     int64
+	}{
+		{"1500000000", false, 1500000000},
+		{"1500000000.75", false, 1500000000},
+		{"1500000000123", false, 1500000000123},
+		{"1500000000", true, 1500000000000},
+		{"1500000000.75", true, 1500000000750},
+		{"1500000000123", true, 1500000000123},
+	}
+	for _, test := range tests {
+		Milliseconds = test.milliseconds
+		ts, err := parseTimestamp(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts != test.expected {
+			t.Errorf("parseTimestamp(%s) with Milliseconds %v: expected %d, got %d", test.in, test.milliseconds, test.expected, ts)
+		}
+	}
+}
+
+// testCollector sends dps once and returns.
+type testCollector struct {
+	dps opentsdb.MultiDataPoint
+}
+
+func (c *testCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
+	for _, dp := range c.dps {
+		dpchan <- dp
+	}
+}
+
+func (c *testCollector) Name() string { return "test" }
+
+func (c *testCollector) Init() {}
+
+func TestConfigure(t *testing.T) {
+	if _, err := Configure(&PutListener{}, Settings{Interval: time.Minute}); err == nil {
+		t.Error("expected error setting the interval of a listener")
+	}
+	if _, err := Configure(&ProgramCollector{Path: "test"}, Settings{Timeout: time.Minute}); err == nil {
+		t.Error("expected error setting the timeout of a continuous program")
+	}
+	if _, err := Configure(&StatsdListener{}, Settings{Prefix: "p."}); err == nil {
+		t.Error("expected error setting the prefix of the StatsD listener")
+	}
+	if _, err := Configure(&IntervalCollector{}, Settings{Prefix: "p q."}); err == nil {
+		t.Error("expected error setting an invalid prefix")
+	}
+	ic := &IntervalCollector{}
+	if _, err := Configure(ic, Settings{Interval: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if ic.Interval != time.Minute {
+		t.Errorf("expected interval 1m, got %s", ic.Interval)
+	}
+	c, err := Configure(&testCollector{dps: opentsdb.MultiDataPoint{
+		{Metric: "test.value", Timestamp: 1, Value: 1, Tags: opentsdb.TagSet{"host": "h", "dc": "a"}},
+	}}, Settings{Tags: opentsdb.TagSet{"dc": "b"}, Prefix: "p."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *opentsdb.DataPoint, 1)
+	c.Run(ch)
+	dp := <-ch
+	if s := dp.Metric + dp.Tags.String(); s != "p.test.value{dc=b,host=h}" {
+		t.Errorf("expected p.test.value{dc=b,host=h}, got %s", s)
+	}
+}
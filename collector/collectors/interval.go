@@ -1,6 +1,7 @@
 package collectors
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os/exec"
@@ -8,6 +9,7 @@ import (
 	"regexp"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -22,10 +24,12 @@ type IntervalCollector struct {
 	Enable   func() bool
 	name     string
 	init     func()
+	timeout  time.Duration // set by Configure
 
 	// internal use
 	sync.Mutex
 	enabled bool
+	running int32 // a run, possibly timed out, has not returned yet
 }
 
 func (c *IntervalCollector) Init() {
@@ -52,10 +56,11 @@ func (c *IntervalCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
 		if interval == 0 {
 			interval = DefaultFreq
 		}
+		interval, skip := throttled(c.Name(), interval)
 		next := time.After(interval)
-		if c.Enabled() {
+		if c.Enabled() && !skip {
 			timeStart := time.Now()
-			md, err := c.F()
+			md, err := c.run()
 			timeFinish := time.Since(timeStart)
 			result := 0
 			if err != nil {
@@ -71,7 +76,38 @@ func (c *IntervalCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
 				dpchan <- dp
 			}
 		}
-		<-next
+		select {
+		case <-next:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// run calls F, giving up after the timeout set by Configure, if any. A run
+// that timed out keeps the collector from running until it returns.
+func (c *IntervalCollector) run() (opentsdb.MultiDataPoint, error) {
+	if c.timeout == 0 {
+		return c.F()
+	}
+	if !atomic.CompareAndSwapInt32(&c.running, 0, 1) {
+		return nil, fmt.Errorf("previous run still running")
+	}
+	type result struct {
+		md  opentsdb.MultiDataPoint
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		md, err := c.F()
+		atomic.StoreInt32(&c.running, 0)
+		ch <- result{md, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.md, r.err
+	case <-time.After(c.timeout):
+		return nil, fmt.Errorf("timed out after %s", c.timeout)
 	}
 }
 
@@ -22,6 +22,9 @@ import (
 type ProgramCollector struct {
 	Path     string
 	Interval time.Duration
+	// Timeout, if not zero, kills the program of a collector with an
+	// Interval if it runs longer.
+	Timeout time.Duration
 }
 
 func InitPrograms(cpath string) {
@@ -87,14 +90,25 @@ func (c *ProgramCollector) Run(dpchan chan<- *opentsdb.DataPoint) {
 			if err := c.runProgram(dpchan); err != nil {
 				log.Infoln(err)
 			}
-			<-next
+			select {
+			case <-next:
+			case <-stop:
+				return
+			}
 			log.Infoln("restarting", c.Path)
 		}
 	} else {
 		for {
-			next := time.After(c.Interval)
-			c.runProgram(dpchan)
-			<-next
+			interval, skip := throttled(c.Name(), c.Interval)
+			next := time.After(interval)
+			if !skip {
+				c.runProgram(dpchan)
+			}
+			select {
+			case <-next:
+			case <-stop:
+				return
+			}
 		}
 	}
 }
@@ -115,6 +129,13 @@ func (c *ProgramCollector) runProgram(dpchan chan<- *opentsdb.DataPoint) (progEr
 	if err := cmd.Start(); err != nil {
 		return err
 	}
+	if c.Interval != 0 && c.Timeout != 0 {
+		t := time.AfterFunc(c.Timeout, func() {
+			log.Errorf("%s: killed after %s", c.Path, c.Timeout)
+			cmd.Process.Kill()
+		})
+		defer t.Stop()
+	}
 	go func() {
 		progError = cmd.Wait()
 		pw.Close()
@@ -143,6 +164,7 @@ func (c *ProgramCollector) runProgram(dpchan chan<- *opentsdb.DataPoint) (progEr
 		if err := json.Unmarshal([]byte(t), &dp); err != nil {
 			errs = append(errs, fmt.Errorf("opentsdb.DataPoint: %v", err))
 		} else if dp.Valid() {
+			dp.Timestamp = normalizeTimestamp(dp.Timestamp)
 			if dp.Tags == nil {
 				dp.Tags = opentsdb.TagSet{}
 			}
@@ -200,7 +222,7 @@ func parseTcollectorValue(line string) (*opentsdb.DataPoint, error) {
 	if len(sp) < 3 {
 		return nil, fmt.Errorf("bad line: %s", line)
 	}
-	ts, err := strconv.ParseInt(sp[1], 10, 64)
+	ts, err := parseTimestamp(sp[1])
 	if err != nil {
 		return nil, fmt.Errorf("bad timestamp: %s", sp[1])
 	}
@@ -0,0 +1,148 @@
+package collectors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/metadata"
+	"mosun_collector/opentsdb"
+)
+
+// maxPutBody is the maximum size of a decompressed request body.
+const maxPutBody = 32 * 1024 * 1024
+
+// PutListener is a collector accepting data points and metadata pushed by
+// local applications on the OpenTSDB-compatible /api/put and
+// /api/metadata/put routes. Pushed data gets the same host, license and
+// AddTags tags as collected data.
+type PutListener struct {
+	Addr string
+}
+
+func (l *PutListener) Init() {}
+
+func (l *PutListener) Name() string {
+	return "api_put"
+}
+
+func (l *PutListener) Run(dpchan chan<- *opentsdb.DataPoint) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/put", func(w http.ResponseWriter, r *http.Request) {
+		putDataPoints(w, r, dpchan)
+	})
+	mux.HandleFunc("/api/metadata/put", putMetadata)
+	log.Infof("Accepting data points at http://%s/api/put", l.Addr)
+	log.Fatal(http.ListenAndServe(l.Addr, mux))
+}
+
+// readPutBody returns the body of r, decompressed if needed.
+func readPutBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if r.Method != "POST" {
+		return nil, fmt.Errorf("method not allowed: %s", r.Method)
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxPutBody)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		g, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer g.Close()
+		body = io.LimitReader(g, maxPutBody)
+	}
+	return ioutil.ReadAll(body)
+}
+
+type putError struct {
+	Datapoint *opentsdb.DataPoint `json:"datapoint"`
+	Error     string              `json:"error"`
+}
+
+func putDataPoints(w http.ResponseWriter, r *http.Request, dpchan chan<- *opentsdb.DataPoint) {
+	b, err := readPutBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var dps opentsdb.MultiDataPoint
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		err = json.Unmarshal(b, &dps)
+	} else {
+		var dp opentsdb.DataPoint
+		err = json.Unmarshal(b, &dp)
+		dps = opentsdb.MultiDataPoint{&dp}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var errs []putError
+	for _, dp := range dps {
+		if dp == nil || !dp.Valid() || !opentsdb.ValidTag(dp.Metric) {
+			errs = append(errs, putError{dp, "invalid data point"})
+			continue
+		}
+		if dp.Tags == nil {
+			dp.Tags = opentsdb.TagSet{}
+		}
+		setPushTags(dp.Tags)
+		dpchan <- dp
+	}
+	_, details := r.URL.Query()["details"]
+	if len(errs) == 0 && !details {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Success int        `json:"success"`
+		Failed  int        `json:"failed"`
+		Errors  []putError `json:"errors"`
+	}{len(dps) - len(errs), len(errs), errs})
+}
+
+func putMetadata(w http.ResponseWriter, r *http.Request) {
+	b, err := readPutBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ms []metadata.Metasend
+	if err := json.Unmarshal(b, &ms); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, m := range ms {
+		if m.Tags == nil {
+			m.Tags = opentsdb.TagSet{}
+		}
+		setExternalTags(m.Tags)
+		if m.Value == nil || m.Value == "" || m.Name == "" || (m.Metric == "" && len(m.Tags) == 0) {
+			http.Error(w, fmt.Sprintf("invalid metadata: %+v", m), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, m := range ms {
+		metadata.AddMeta(m.Metric, m.Tags, m.Name, m.Value, false)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setPushTags is setExternalTags plus the license tag, which is set to
+// License if unspecified, or removed if present and empty.
+func setPushTags(tags opentsdb.TagSet) {
+	setExternalTags(tags)
+	if ls, p := tags["license"]; !p {
+		tags["license"] = License
+	} else if ls == "" {
+		delete(tags, "license")
+	}
+}
@@ -0,0 +1,83 @@
+package collectors
+
+import (
+	"fmt"
+	"time"
+
+	"mosun_collector/opentsdb"
+)
+
+// Settings override the configuration of a single collector.
+type Settings struct {
+	// Interval replaces the collection interval. Zero keeps it.
+	Interval time.Duration
+	// Timeout is the maximum duration of a run. Zero means no limit.
+	Timeout time.Duration
+	// Tags are added to every data point, replacing tags of the same key.
+	Tags opentsdb.TagSet
+	// Prefix is prepended to every metric name.
+	Prefix string
+}
+
+// Configure applies s to c and returns the collector to run instead of c.
+// Interval and Timeout are only supported by interval and program
+// collectors, and Timeout only by programs run periodically. Tags and Prefix
+// are not supported by the StatsD listener, which sends its data to collect
+// directly.
+func Configure(c Collector, s Settings) (Collector, error) {
+	switch c := c.(type) {
+	case *IntervalCollector:
+		if s.Interval != 0 {
+			c.Interval = s.Interval
+		}
+		c.timeout = s.Timeout
+	case *ProgramCollector:
+		if s.Interval != 0 {
+			c.Interval = s.Interval
+		}
+		if s.Timeout != 0 && c.Interval == 0 {
+			return nil, fmt.Errorf("%s: Timeout requires an Interval, the program runs continuously", c.Name())
+		}
+		c.Timeout = s.Timeout
+	default:
+		if s.Interval != 0 || s.Timeout != 0 {
+			return nil, fmt.Errorf("%s: Interval and Timeout are not supported", c.Name())
+		}
+	}
+	if len(s.Tags) == 0 && s.Prefix == "" {
+		return c, nil
+	}
+	if _, ok := c.(*StatsdListener); ok {
+		return nil, fmt.Errorf("%s: Tags and Prefix are not supported", c.Name())
+	}
+	if !s.Tags.Valid() {
+		return nil, fmt.Errorf("%s: invalid tags %v", c.Name(), s.Tags)
+	}
+	if s.Prefix != "" && !opentsdb.ValidTag(s.Prefix) {
+		return nil, fmt.Errorf("%s: invalid prefix %q", c.Name(), s.Prefix)
+	}
+	return &configured{Collector: c, tags: s.Tags, prefix: s.Prefix}, nil
+}
+
+// configured adds tags and a metric prefix to the data of its collector.
+type configured struct {
+	Collector
+	tags   opentsdb.TagSet
+	prefix string
+}
+
+func (c *configured) Run(dpchan chan<- *opentsdb.DataPoint) {
+	ch := make(chan *opentsdb.DataPoint)
+	go func() {
+		c.Collector.Run(ch)
+		close(ch)
+	}()
+	for dp := range ch {
+		d := *dp
+		d.Metric = c.prefix + dp.Metric
+		if len(c.tags) > 0 {
+			d.Tags = dp.Tags.Copy().Merge(c.tags)
+		}
+		dpchan <- &d
+	}
+}
@@ -0,0 +1,213 @@
+package collectors
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"mosun_collector/collect"
+	"mosun_collector/opentsdb"
+)
+
+// StatsdListener is a collector accepting StatsD metrics on UDP and TCP.
+// Counters are passed to collect.Add, gauges to collect.Put, timers to
+// collect.SampleN and set sizes to collect.Set, so they are flushed every
+// collect.Freq under the collector metric root. Sets are emptied when their
+// size is flushed. DogStatsD tags (|#key:value,...) are supported.
+type StatsdListener struct {
+	Addr string
+
+	sync.Mutex
+	gauges map[string]float64         // current gauge values, for relative updates
+	sets   map[string]map[string]bool // unique set members in this interval
+	counts map[string]float64         // fractions of counts not added yet
+}
+
+func (s *StatsdListener) Init() {}
+
+func (s *StatsdListener) Name() string {
+	return "statsd"
+}
+
+func (s *StatsdListener) Run(dpchan chan<- *opentsdb.DataPoint) {
+	s.gauges = make(map[string]float64)
+	s.sets = make(map[string]map[string]bool)
+	s.counts = make(map[string]float64)
+	go s.listenTCP()
+	pc, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Accepting StatsD metrics at %s", s.Addr)
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.handle(line)
+		}
+	}
+}
+
+func (s *StatsdListener) listenTCP() {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			sc := bufio.NewScanner(conn)
+			for sc.Scan() {
+				s.handle(sc.Text())
+			}
+		}()
+	}
+}
+
+func (s *StatsdListener) handle(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := parseStatsd(line)
+	if err != nil {
+		log.Debugf("statsd: %v", err)
+		return
+	}
+	tags := AddTags.Copy().Merge(m.tags)
+	switch m.typ {
+	case "c":
+		err = collect.Add(m.name, tags, s.count(m.name+tags.String(), m.value/m.rate))
+	case "g":
+		key := m.name + tags.String()
+		s.Lock()
+		if m.relative {
+			m.value += s.gauges[key]
+		}
+		s.gauges[key] = m.value
+		s.Unlock()
+		err = collect.Put(m.name, tags, m.value)
+	case "ms", "h", "d":
+		err = collect.SampleN(m.name, tags, m.value, s.count(m.name+tags.String(), 1/m.rate))
+	case "s":
+		key := m.name + tags.String()
+		s.Lock()
+		members, ok := s.sets[key]
+		if !ok {
+			members = make(map[string]bool)
+			s.sets[key] = members
+		}
+		members[m.member] = true
+		s.Unlock()
+		if !ok {
+			err = collect.Set(m.name, tags, s.setSize(key))
+		}
+	}
+	if err != nil {
+		log.Debugf("statsd: %s: %v", line, err)
+	}
+}
+
+// count returns the whole part of v plus the fraction carried over from the
+// previous counts of key, and carries over the new fraction. Sampled and
+// fractional counters are thus not truncated.
+func (s *StatsdListener) count(key string, v float64) int64 {
+	s.Lock()
+	defer s.Unlock()
+	v += s.counts[key]
+	n := int64(v)
+	s.counts[key] = v - float64(n)
+	return n
+}
+
+// setSize returns the collect.Set callback reporting the number of members
+// of the set key since the last flush and emptying it. collect calls it when
+// flushing, so the size covers exactly one interval.
+func (s *StatsdListener) setSize(key string) func() interface{} {
+	return func() interface{} {
+		s.Lock()
+		defer s.Unlock()
+		n := len(s.sets[key])
+		s.sets[key] = make(map[string]bool)
+		return n
+	}
+}
+
+type statsdMetric struct {
+	name     string
+	typ      string
+	value    float64
+	member   string // set member
+	relative bool   // gauge value is a delta
+	rate     float64
+	tags     opentsdb.TagSet
+}
+
+// parseStatsd parses a <name>:<value>|<type>[|@<rate>][|#<tags>] line.
+// Names and tags are cleaned to be valid OpenTSDB metrics and tags.
+func parseStatsd(line string) (*statsdMetric, error) {
+	fields := strings.Split(line, "|")
+	i := strings.LastIndex(fields[0], ":")
+	if i <= 0 || len(fields) < 2 {
+		return nil, fmt.Errorf("bad line: %s", line)
+	}
+	fields[0] = fields[0][i+1:]
+	m := &statsdMetric{
+		name: opentsdb.MustReplace(line[:i], "_"),
+		typ:  fields[1],
+		rate: 1,
+		tags: opentsdb.TagSet{},
+	}
+	if m.name == "" {
+		return nil, fmt.Errorf("bad metric: %s", line)
+	}
+	switch m.typ {
+	case "s":
+		m.member = fields[0]
+	case "c", "g", "ms", "h", "d":
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value: %s", line)
+		}
+		m.value = v
+		m.relative = m.typ == "g" && (fields[0][0] == '+' || fields[0][0] == '-')
+	default:
+		return nil, fmt.Errorf("bad type: %s", line)
+	}
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			r, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return nil, fmt.Errorf("bad sample rate: %s", line)
+			}
+			m.rate = r
+		case strings.HasPrefix(f, "#"):
+			for _, t := range strings.Split(f[1:], ",") {
+				kv := strings.SplitN(t, ":", 2)
+				k := opentsdb.MustReplace(kv[0], "_")
+				v := "true"
+				if len(kv) == 2 {
+					v = opentsdb.MustReplace(kv[1], "_")
+				}
+				if k != "" && v != "" {
+					m.tags[k] = v
+				}
+			}
+		}
+	}
+	return m, nil
+}
@@ -6,8 +6,14 @@ import (
 )
 
 type Conf struct {
-	// Host is the OpenTSDB or Bosun host to send data.
+	// Host is the OpenTSDB or Bosun host to send data. Several hosts may be
+	// given, separated by commas, to fail over or balance between them.
 	Host string
+	// HostStrategy selects how data is spread over several hosts:
+	//   failover: to the first healthy host, in order (default)
+	//   roundrobin: each batch to the next healthy host
+	//   hash: each series always to the same healthy host
+	HostStrategy string
 	// SchedHost is the Schedule host to send metadata.
 	SchedHost string
 	// FullHost enables full hostnames: doesn't truncate to first ".".
@@ -25,13 +31,68 @@ type Conf struct {
 	Freq int
 	// BatchSize is the number of metrics that will be sent in each batch.
 	BatchSize int
+	// Milliseconds stamps data points with the time they are collected at,
+	// in milliseconds, instead of the current second.
+	Milliseconds bool
+	// BatchBytes, if not 0, also limits the size of each batch, estimated
+	// as its JSON encoding before compression.
+	BatchBytes int
+	// Senders is the number of batches each output sends concurrently.
+	// Defaults to 1.
+	Senders int
+	// FlushInterval is how long a partial batch waits for more data before
+	// it is sent, e.g. "500ms". Full batches are sent at once. Defaults to
+	// 1s.
+	FlushInterval string
 	// Filter filters collectors matching these terms.
 	Filter []string
+	// Collectors configures single collectors, by the name shown by the
+	// list command, e.g. [Collectors.c_cpu_linux].
+	Collectors map[string]Collector
 	// PProf is an IP:Port binding to be used for debugging with pprof package.
 	// Examples: localhost:6060 for loopback or :6060 for all IP addresses.
 	PProf string
+	// MetricsListen is an IP:Port binding serving the latest value of every
+	// series in the Prometheus text format at /metrics. Disabled if empty.
+	MetricsListen string
+	// PutListen is an IP:Port binding accepting data points and metadata from
+	// local applications on /api/put and /api/metadata/put. Disabled if
+	// empty.
+	PutListen string
+	// StatsdListen is an IP:Port binding accepting StatsD metrics on UDP and
+	// TCP. Disabled if empty.
+	StatsdListen string
 
 	License string
+	// HTTP configures TLS, authentication and proxy for the HTTP requests
+	// sent to Host, SchedHost and the HTTP outputs.
+	HTTP HTTP
+	// Spool configures the on-disk spool used when the send queue is full.
+	Spool Spool
+	// Retry configures how failed batches are retried.
+	Retry Retry
+	// Relabel lists rules applied in order to every data point before it is
+	// queued, to drop series, rename metrics and rewrite tags.
+	Relabel []Relabel
+	// Cardinality limits the number of series per metric.
+	Cardinality Cardinality
+	// Dedup configures change-only sending.
+	Dedup Dedup
+	// Rate lists counters converted to per-second rates before sending, for
+	// backends without rate functions.
+	Rate []Rate
+	// Memory configures how the collector reacts to high memory usage.
+	Memory Memory
+	// DeadLetter is a file data points rejected by Host are appended to.
+	DeadLetter string
+	// ShutdownTimeout is how long queued data is sent after SIGTERM or
+	// SIGINT before the rest is spooled and the collector exits. Defaults
+	// to 30s.
+	ShutdownTimeout string
+	// Output lists additional destinations. Every output receives all data
+	// and has its own queue, so a failing output does not hold back the
+	// others.
+	Output []Output
 	// KeepalivedCommunity, if not empty, enables the Keepalived collector with
 	// the specified community.
 	KeepalivedCommunity string
@@ -47,6 +108,190 @@ type Conf struct {
 	HTTPUnit      []HTTPUnit
 }
 
+type Collector struct {
+	// Interval replaces the collection interval, e.g. "1m". Only for
+	// interval and program collectors.
+	Interval string
+	// Timeout is the maximum duration of a run, e.g. "10s". Only for
+	// interval collectors and programs run with an Interval.
+	Timeout string
+	// Enabled, if set, runs or disables the collector regardless of Filter.
+	Enabled *bool
+	// Tags are added to the data of the collector, replacing tags of the
+	// same key. Not supported by the statsd listener.
+	Tags opentsdb.TagSet
+	// Prefix is prepended to the metric names of the collector, e.g. "dc1.".
+	// Not supported by the statsd listener.
+	Prefix string
+}
+
+type HTTP struct {
+	// CAFile is a PEM bundle of the CAs trusted instead of the system ones.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables server certificate verification.
+	InsecureSkipVerify bool
+	// Username and Password enable basic authentication.
+	Username string
+	Password string
+	// BearerToken is sent as "Authorization: Bearer <token>".
+	BearerToken string
+	// Headers are added to every request.
+	Headers map[string]string
+	// Proxy is the URL of the HTTP proxy to use.
+	Proxy string
+}
+
+type Spool struct {
+	// Dir is the spool directory. The spool is disabled if empty.
+	Dir string
+	// MaxBytes is the maximum size of the spool in bytes. Defaults to 1GB.
+	MaxBytes int64
+	// MaxAge is the maximum age of spooled data, e.g. "24h". Older data is
+	// discarded.
+	MaxAge string
+}
+
+type Relabel struct {
+	// Metric matches metric names with a glob, e.g. os.net.*, or with a
+	// regular expression between slashes, e.g. /^os\.(cpu|mem)\./. Matches
+	// every metric if empty.
+	Metric string
+	// Tags match tag values the same way. A data point missing one of the
+	// tags does not match.
+	Tags map[string]string
+	// Action is one of:
+	//   drop: drop the matching data points
+	//   keep: drop the data points not matching
+	//   rename: set the metric name to Value
+	//   settag: set Tag to Value
+	//   deltag: delete Tag
+	//   replace: replace Regex by Value in Tag, or in the metric name if
+	//   Tag is empty. Value may refer to submatches as $1.
+	Action string
+	Tag    string
+	Value  string
+	Regex  string
+}
+
+type Cardinality struct {
+	// MaxSeries is the number of distinct tag sets a metric may have within
+	// Window. New series past the limit are dropped. Disabled if 0.
+	MaxSeries int
+	// Window is how long a series counts towards the limit after its last
+	// data point. Defaults to 1h.
+	Window string
+	// Limits overrides MaxSeries for the metrics matching each key, a glob
+	// or a regular expression between slashes. 0 means no limit. If several
+	// keys match a metric, the longest wins.
+	Limits map[string]int
+}
+
+type Dedup struct {
+	// Enabled drops data points whose value equals the last one sent for
+	// their series, to reduce the write load of the TSDB.
+	Enabled bool
+	// Heartbeat is the number of collection intervals (Freq) after which an
+	// unchanged value is sent again anyway. Defaults to 10, or less with a
+	// prometheus output so that the heartbeat is shorter than the 5 minutes
+	// after which Prometheus marks a series stale. The /metrics endpoint
+	// keeps series for at least twice the heartbeat.
+	Heartbeat int
+	// Include lists the metrics deduplicated, as globs or regular
+	// expressions between slashes. Defaults to all metrics.
+	Include []string
+	// Exclude lists metrics never deduplicated.
+	Exclude []string
+}
+
+type Rate struct {
+	// Metric matches the metrics to convert with a glob, e.g. os.net.bytes*,
+	// or with a regular expression between slashes, e.g. /^os\.net\./.
+	Metric string
+	// Bits is the width of the counter, 32 or 64, used when it wraps around.
+	// Detected from the value if 0.
+	Bits int
+	// Suffix is appended to the name of the converted metric, e.g. ".rate".
+	// It is required so that the rate is not mistaken for the counter.
+	Suffix string
+	// Keep also sends the counter.
+	Keep bool
+}
+
+type Memory struct {
+	// MaxBytes is the heap size above which the collector spools its queue
+	// and exits, once all other measures have failed. Defaults to 500MB.
+	MaxBytes uint64
+	// SoftBytes is the heap size above which the collector sheds load: it
+	// moves the queue to the spool, then collects less often, then stops
+	// running the collectors listed in Shed. Defaults to 80% of MaxBytes.
+	SoftBytes uint64
+	// Slowdown is the factor collection intervals are multiplied by while
+	// shedding load. Defaults to 4.
+	Slowdown int
+	// Shed lists collector names, lowest priority first. They stop running,
+	// one by one, while memory stays above SoftBytes. A name matches every
+	// collector containing it.
+	Shed []string
+}
+
+type Output struct {
+	// Name identifies the output in logs and self metrics and names its spool
+	// directory. Defaults to Type.
+	Name string
+	// Type is the kind of destination: "opentsdb", "prometheus" (remote_write),
+	// "influxdb", "graphite", "telnet" (OpenTSDB telnet put) or "file".
+	Type string
+	// Host is the destination host or URL. For the prometheus type this is
+	// the full remote_write URL, e.g. http://prometheus:9090/api/v1/write.
+	// For the influxdb type this is the write URL with its parameters, e.g.
+	// http://influxdb:8086/write?db=collector. For the graphite and telnet
+	// types this is host:port, the port defaulting to 2003 and 4242.
+	Host string
+	// Path is the file data points are appended to, for the file type.
+	Path string
+	// DeadLetter, for the opentsdb type, is a file data points rejected by
+	// the host are appended to.
+	DeadLetter string
+	// SplitField, for the influxdb type, splits the metric at its last dot
+	// into measurement and field: os.net.bytes becomes measurement os.net,
+	// field bytes. Otherwise the measurement is the metric name.
+	SplitField bool
+	// Field is the influxdb field name when SplitField is false. Defaults to
+	// "value".
+	Field string
+	// Prefix, for the graphite type, is prepended to every path.
+	Prefix string
+	// Tagged, for the graphite type, sends Graphite 1.1 tagged series
+	// (os.cpu;host=web01) instead of dotted paths (os.cpu.web01).
+	Tagged bool
+	// BatchSize is the number of data points sent at once. Defaults to the
+	// global BatchSize.
+	BatchSize int
+	// MaxQueueLen is the size of the queue, above which data is spooled or
+	// dropped. Defaults to 200000.
+	MaxQueueLen int
+}
+
+type Retry struct {
+	// MinBackoff and MaxBackoff bound the exponential delay between two
+	// attempts, e.g. "1s" and "2m".
+	MinBackoff string
+	MaxBackoff string
+	// MaxRetries is the number of retries before a batch is discarded. Zero
+	// retries forever.
+	MaxRetries int
+	// MaxAge is how long a batch is retried before it is discarded, e.g.
+	// "1h". Empty retries forever.
+	MaxAge string
+	// BreakerThreshold is the number of consecutive failures after which the
+	// circuit breaker opens and holds back sends until the backoff has
+	// passed. Defaults to 5.
+	BreakerThreshold int
+}
+
 type HAProxy struct {
 	User      string
 	Password  string
@@ -59,8 +59,8 @@ func main() {
 			Name:      "utils",
 			ShortName: "u",
 			Usage:     "useful utils.",
-			Flags:     []cli.Flag{base.FlToToml, base.FlConf},
-			Action:    base.ToToml,
+			Flags:     []cli.Flag{base.FlToToml, base.FlRelabel, base.FlConf},
+			Action:    base.Utils,
 		},
 		{
 			Name:      "start",
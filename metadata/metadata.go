@@ -4,6 +4,7 @@ package metadata
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
@@ -124,9 +125,22 @@ var (
 	metadata  = make(map[Metakey]interface{})
 	metalock  sync.Mutex
 	metahost  string
+	dirty     bool // metadata was added since the last full send
 	metafuncs []func()
+	statlock  sync.Mutex
+	sent      int64 // number of successful metadata sends
+	failed    int64 // number of failed metadata sends
+	client    = &http.Client{Timeout: time.Minute}
 )
 
+// SetTransport makes metadata sends use t, adding header to every request.
+func SetTransport(t http.RoundTripper, header http.Header) {
+	client = &http.Client{
+		Transport: &util.HeaderTransport{Transport: t, Header: header},
+		Timeout:   time.Minute,
+	}
+}
+
 // AddMeta adds a metadata entry to memory, which is queued for later sending.
 func AddMeta(metric string, tags opentsdb.TagSet, name string, value interface{}, setHost bool) {
 	if tags == nil {
@@ -152,6 +166,9 @@ func AddMeta(metric string, tags opentsdb.TagSet, name string, value interface{}
 			Value:  value,
 		}})
 	}
+	if !present {
+		dirty = true
+	}
 	metadata[Metakey{metric, ts, name}] = value
 }
 
@@ -164,6 +181,40 @@ func AddMetricMeta(metric string, rate RateType, unit Unit, desc string) {
 	AddMeta(metric, nil, "desc", desc, false)
 }
 
+// MetricInfo is the rate type, unit and description of a metric.
+type MetricInfo struct {
+	Rate RateType
+	Unit Unit
+	Desc string
+}
+
+// Metrics returns the known rate type, unit and description of every
+// metric. A description documented for specific tags is used if the metric
+// has none of its own.
+func Metrics() map[string]MetricInfo {
+	metalock.Lock()
+	defer metalock.Unlock()
+	m := make(map[string]MetricInfo)
+	for k, v := range metadata {
+		mi := m[k.Metric]
+		switch k.Name {
+		case "rate":
+			mi.Rate = RateType(fmt.Sprint(v))
+		case "unit":
+			mi.Unit = Unit(fmt.Sprint(v))
+		case "desc":
+			if mi.Desc != "" && k.Tags != "" {
+				continue
+			}
+			mi.Desc = fmt.Sprint(v)
+		default:
+			continue
+		}
+		m[k.Metric] = mi
+	}
+	return m
+}
+
 // Init initializes the metadata send queue.
 func Init(u *url.URL) error {
 	mh, err := u.Parse("/api/metadata/put")
@@ -183,28 +234,46 @@ func collectMetadata() {
 		for _, f := range metafuncs {
 			f()
 		}
-		metalock.Lock()
-		if len(metadata) == 0 {
-			metalock.Unlock()
+		ms := allMetadata()
+		if len(ms) == 0 {
 			continue
 		}
-		ms := make([]Metasend, len(metadata))
-		i := 0
-		for k, v := range metadata {
-			ms[i] = Metasend{
-				Metric: k.Metric,
-				Tags:   k.TagSet(),
-				Name:   k.Name,
-				Value:  v,
-			}
-			i++
-		}
-		metalock.Unlock()
 		sendMetadata(ms)
 		time.Sleep(time.Hour)
 	}
 }
 
+// allMetadata returns all metadata entries to send and clears the dirty flag.
+func allMetadata() []Metasend {
+	metalock.Lock()
+	defer metalock.Unlock()
+	ms := make([]Metasend, 0, len(metadata))
+	for k, v := range metadata {
+		ms = append(ms, Metasend{
+			Metric: k.Metric,
+			Tags:   k.TagSet(),
+			Name:   k.Name,
+			Value:  v,
+		})
+	}
+	dirty = false
+	return ms
+}
+
+// SendAll sends all metadata now if any was added since it was last sent. It
+// is called on shutdown so that metadata of new metrics is not lost.
+func SendAll() {
+	metalock.Lock()
+	d := dirty
+	metalock.Unlock()
+	if metahost == "" || !d {
+		return
+	}
+	if ms := allMetadata(); len(ms) > 0 {
+		sendMetadata(ms)
+	}
+}
+
 // Metasend is the struct for sending metadata to bosun.
 type Metasend struct {
 	Metric string          `json:",omitempty"`
@@ -222,15 +291,35 @@ func sendMetadata(ms []Metasend) {
 		log.Error(err)
 		return
 	}
-	resp, err := http.Post(metahost, "application/json", bytes.NewBuffer(b))
+	resp, err := client.Post(metahost, "application/json", bytes.NewBuffer(b))
 	if err != nil {
 		log.Error(err)
+		recordSend(false)
 		return
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 204 {
 		body, _ := ioutil.ReadAll(resp.Body)
-		defer resp.Body.Close()
 		log.Errorln("bad metadata return:", resp.Status, " with body:", string(body))
+		recordSend(false)
 		return
 	}
+	recordSend(true)
+}
+
+func recordSend(ok bool) {
+	statlock.Lock()
+	if ok {
+		sent++
+	} else {
+		failed++
+	}
+	statlock.Unlock()
+}
+
+// SendStats returns the number of successful and failed metadata sends.
+func SendStats() (ok, bad int64) {
+	statlock.Lock()
+	defer statlock.Unlock()
+	return sent, failed
 }
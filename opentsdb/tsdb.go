@@ -66,6 +66,10 @@ type DataPoint struct {
 	Tags      TagSet      `json:"tags"`
 }
 
+// MaxSecondTimestamp is the largest DataPoint timestamp taken to be in
+// seconds, as in OpenTSDB. Larger ones are in milliseconds.
+const MaxSecondTimestamp = 9999999999
+
 // MarshalJSON verifies d is valid and converts it to JSON.
 func (d *DataPoint) MarshalJSON() ([]byte, error) {
 	if err := d.clean(); err != nil {
@@ -84,6 +88,13 @@ func (d *DataPoint) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// Clean removes the characters invalid for OpenTSDB from the metric and tags
+// of d and converts string values to numbers, as MarshalJSON does. Once d is
+// clean, MarshalJSON does not modify it.
+func (d *DataPoint) Clean() error {
+	return d.clean()
+}
+
 // Valid returns whether d contains valid data (populated fields, valid tags)
 // for submission to OpenTSDB.
 func (d *DataPoint) Valid() bool {
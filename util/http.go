@@ -0,0 +1,72 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// NewTransport returns an http.Transport trusting the CA certificates in
+// caFile, presenting the client certificate in certFile and keyFile and
+// connecting through proxy. Empty arguments keep the settings of
+// http.DefaultTransport, including the proxy from the environment.
+func NewTransport(caFile, certFile, keyFile string, insecureSkipVerify bool, proxy string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy: %v", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if caFile == "" && certFile == "" && keyFile == "" && !insecureSkipVerify {
+		return t, nil
+	}
+	c := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	t.TLSClientConfig = c
+	return t, nil
+}
+
+// HeaderTransport adds Header to every request, e.g. for authentication,
+// before passing it to Transport.
+type HeaderTransport struct {
+	Transport http.RoundTripper
+	Header    http.Header
+}
+
+func (t *HeaderTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if len(t.Header) == 0 {
+		return t.Transport.RoundTrip(r)
+	}
+	// RoundTrippers must not modify the request.
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header)+len(t.Header))
+	for k, v := range r.Header {
+		r2.Header[k] = v
+	}
+	for k, v := range t.Header {
+		r2.Header[k] = v
+	}
+	return t.Transport.RoundTrip(r2)
+}